@@ -23,11 +23,29 @@ import (
 	"github.com/onsi/ginkgo/config"
 	"github.com/onsi/gomega"
 	runtimeutils "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 
 	"kusionstack.io/operating/test/e2e/framework"
 )
 
+// SynchronizedBeforeSuite waits for the operating controllers and webhooks to be healthy on Ginkgo node 1, so that
+// the whole suite does not fail against a half-started controller-manager.
+var _ = ginkgo.SynchronizedBeforeSuite(func() []byte {
+	// Run only on Ginkgo node 1
+	c, err := framework.LoadClientset()
+	framework.ExpectNoError(err)
+	f := &framework.Framework{ClientSet: c}
+	framework.ExpectNoError(f.AssertControllerNotCrashLooping())
+	framework.ExpectNoError(f.WaitForControllerHealthy(framework.TestContext.SystemPodsStartupTimeout))
+	framework.ExpectNoError(f.WaitForWebhookActive(framework.MutatingWebhookConfigurationName, framework.TestContext.SystemPodsStartupTimeout))
+	framework.ExpectNoError(f.WaitForWebhookActive(framework.ValidatingWebhookConfigurationName, framework.TestContext.SystemPodsStartupTimeout))
+	return nil
+}, func(data []byte) {
+	// Run on all Ginkgo nodes
+})
+
 // Similar to SynchronizedBeforeSuite, we want to run some operations only once (such as collecting cluster logs).
 // Here, the order of functions is reversed; first, the function which runs everywhere,
 // and then the function that only runs on the first Ginkgo node.
@@ -38,6 +56,11 @@ var _ = ginkgo.SynchronizedAfterSuite(func() {
 }, func() {
 	// Run only Ginkgo on node 1
 	framework.Logf("Running AfterSuite actions on node 1")
+	if dir := framework.TestContext.ClusterSnapshotDir; dir != "" {
+		if err := collectClusterSnapshot(dir); err != nil {
+			framework.Logf("Error collecting cluster snapshot: %v", err)
+		}
+	}
 	//if framework.TestContext.GatherSuiteMetricsAfterTest {
 	//	if err := gatherTestSuiteMetrics(); err != nil {
 	//		framework.Logf("Error gathering metrics: %v", err)
@@ -45,6 +68,24 @@ var _ = ginkgo.SynchronizedAfterSuite(func() {
 	//}
 })
 
+// collectClusterSnapshot collects the snapshot of the whole cluster into dir.
+func collectClusterSnapshot(dir string) error {
+	restConfig, err := framework.LoadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := clientset.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	dc, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	f := &framework.Framework{ClientSet: c, DynamicClient: dc}
+	return f.CollectClusterSnapshot(dir)
+}
+
 // RunE2ETests checks configuration parameters (specified through flags) and then runs
 // E2E tests using the Ginkgo runner.
 // If a "report directory" is specified, one or more JUnit test reports will be
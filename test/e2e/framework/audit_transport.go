@@ -0,0 +1,74 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+)
+
+// auditRoundTripper logs the verb, path, headers and response status of each request sent through it,
+// with the Authorization header redacted.
+type auditRoundTripper struct {
+	delegate http.RoundTripper
+	out      io.Writer
+}
+
+// newAuditRoundTripper wraps the round tripper to audit the requests into the ginkgo writer.
+func newAuditRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return &auditRoundTripper{delegate: rt, out: ginkgo.GinkgoWriter}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *auditRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.delegate.RoundTrip(req)
+	status := ""
+	if err != nil {
+		status = fmt.Sprintf("error: %v", err)
+	} else {
+		status = resp.Status
+	}
+	fmt.Fprintf(rt.out, "%s AUDIT: %s %s %s in %v, headers: %s\n",
+		nowStamp(), req.Method, req.URL.RequestURI(), status, time.Since(start), redactHeaders(req.Header))
+	return resp, err
+}
+
+// redactHeaders formats the headers with the values of Authorization headers redacted.
+func redactHeaders(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := strings.Join(header[k], ",")
+		if strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Proxy-Authorization") {
+			value = "<redacted>"
+		}
+		fields = append(fields, fmt.Sprintf("%s=%s", k, value))
+	}
+	return "[" + strings.Join(fields, " ") + "]"
+}
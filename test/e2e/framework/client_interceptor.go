@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// interceptingClient invokes intercept before each operation of the wrapped client, and fails the operation
+// with the error it returns, if any.
+type interceptingClient struct {
+	client.Client
+	intercept func(op string, obj client.Object) error
+}
+
+func (c *interceptingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.intercept("get", obj); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *interceptingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.intercept("list", nil); err != nil {
+		return err
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *interceptingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.intercept("create", obj); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *interceptingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.intercept("delete", obj); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *interceptingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.intercept("update", obj); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *interceptingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.intercept("patch", obj); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *interceptingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.intercept("deleteallof", obj); err != nil {
+		return err
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *interceptingClient) Status() client.StatusWriter {
+	return &interceptingStatusWriter{StatusWriter: c.Client.Status(), intercept: c.intercept}
+}
+
+// interceptingStatusWriter intercepts the operations on the status subresource as status-update and status-patch.
+type interceptingStatusWriter struct {
+	client.StatusWriter
+	intercept func(op string, obj client.Object) error
+}
+
+func (w *interceptingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := w.intercept("status-update", obj); err != nil {
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *interceptingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := w.intercept("status-patch", obj); err != nil {
+		return err
+	}
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+// SetClientInterceptor wraps f.Client so that fn is invoked before each of its operations, one of get, list,
+// create, update, patch, delete, deleteallof, status-update and status-patch, and the operation fails with the
+// error fn returns, if any, e.g. to simulate intermittent apiserver errors. obj is nil for list. Setting another
+// interceptor replaces the previous one, and a nil fn clears it. The interceptor is cleared in AfterEach.
+func (f *Framework) SetClientInterceptor(fn func(op string, obj client.Object) error) {
+	if c, ok := f.Client.(*interceptingClient); ok {
+		f.Client = c.Client
+	}
+	if fn == nil {
+		return
+	}
+
+	c := &interceptingClient{Client: f.Client, intercept: fn}
+	f.Client = c
+	f.addCleanup(func() {
+		if f.Client == c {
+			f.Client = c.Client
+		}
+	})
+}
@@ -0,0 +1,1400 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1alpha1 "kusionstack.io/operating/apis/apps/v1alpha1"
+	"kusionstack.io/operating/pkg/controllers/collaset/podcontext"
+	collasetutils "kusionstack.io/operating/pkg/controllers/collaset/utils"
+	"kusionstack.io/operating/pkg/controllers/utils/podopslifecycle"
+)
+
+// NewCollaSet builds a CollaSet in the framework namespace selecting the labels of the given template, which
+// default to app=<name>. It is always sent as v1alpha1, since the client encodes typed objects by the scheme.
+func (f *Framework) NewCollaSet(name string, replicas int32, template corev1.PodTemplateSpec) *appsv1alpha1.CollaSet {
+	template, selector := workloadTemplate(name, template)
+
+	return &appsv1alpha1.CollaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: f.Namespace.Name,
+			Name:      name,
+		},
+		Spec: appsv1alpha1.CollaSetSpec{
+			Replicas: &replicas,
+			Selector: selector,
+			Template: template,
+		},
+	}
+}
+
+// collaSetPodUpdatePolicies are the known policies by which a CollaSet updates its pods.
+var collaSetPodUpdatePolicies = sets.NewString(
+	string(appsv1alpha1.CollaSetRecreatePodUpdateStrategyType),
+	string(appsv1alpha1.CollaSetInPlaceIfPossiblePodUpdateStrategyType),
+	string(appsv1alpha1.CollaSetInPlaceOnlyPodUpdateStrategyType),
+	string(appsv1alpha1.CollaSetReplacePodUpdateStrategyType),
+)
+
+// NewCollaSetWithUpdateStrategy builds a CollaSet like NewCollaSet, which updates its pods by the given policy, i.e.
+// .spec.updateStrategy.podUpgradePolicy. It fails the test if the policy is unknown.
+func (f *Framework) NewCollaSetWithUpdateStrategy(name string, replicas int32, template corev1.PodTemplateSpec, strategy string) *appsv1alpha1.CollaSet {
+	if !collaSetPodUpdatePolicies.Has(strategy) {
+		Failf("unknown pod update policy %q of CollaSet %s, expected one of %v", strategy, name, collaSetPodUpdatePolicies.List())
+	}
+	cls := f.NewCollaSet(name, replicas, template)
+	cls.Spec.UpdateStrategy.PodUpdatePolicy = appsv1alpha1.PodUpdateStrategyType(strategy)
+	return cls
+}
+
+// GetCollaSet gets the CollaSet with the given name in the framework namespace.
+func (f *Framework) GetCollaSet(name string) (*appsv1alpha1.CollaSet, error) {
+	cls := &appsv1alpha1.CollaSet{}
+	err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: f.Namespace.Name, Name: name}, cls)
+	return cls, err
+}
+
+// UpdateCollaSet applies the update function on the latest CollaSet and updates it, retrying on conflicts.
+func (f *Framework) UpdateCollaSet(name string, updateFn func(cls *appsv1alpha1.CollaSet)) (*appsv1alpha1.CollaSet, error) {
+	var cls *appsv1alpha1.CollaSet
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		if cls, err = f.GetCollaSet(name); err != nil {
+			return err
+		}
+		updateFn(cls)
+		return f.Client.Update(context.TODO(), cls)
+	})
+	return cls, err
+}
+
+// ScaleCollaSet sets the replicas of the CollaSet.
+func (f *Framework) ScaleCollaSet(name string, replicas int32) (*appsv1alpha1.CollaSet, error) {
+	Logf("Scaling CollaSet %s/%s to %d replicas", f.Namespace.Name, name, replicas)
+	return f.UpdateCollaSet(name, func(cls *appsv1alpha1.CollaSet) {
+		cls.Spec.Replicas = &replicas
+	})
+}
+
+// CreateCollaSetAndWaitReady creates the CollaSet, waits until it has observed its spec and all its replicas are
+// ready, and returns its pods.
+func (f *Framework) CreateCollaSetAndWaitReady(cls *appsv1alpha1.CollaSet, timeout time.Duration) ([]corev1.Pod, error) {
+	Logf("Creating CollaSet %s/%s", cls.Namespace, cls.Name)
+	if err := f.Client.Create(context.TODO(), cls); err != nil {
+		return nil, err
+	}
+	f.TrackObject(cls)
+
+	replicas := int32(1)
+	if cls.Spec.Replicas != nil {
+		replicas = *cls.Spec.Replicas
+	}
+	Logf("Waiting up to %v for %d replicas of CollaSet %s/%s to be ready", timeout, replicas, cls.Namespace, cls.Name)
+	var pods []corev1.Pod
+	var ready int32
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		ready = 0
+		for i := range pods {
+			cond := getPodReadyCondition(&pods[i])
+			if pods[i].DeletionTimestamp == nil && cond != nil && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+		return cls.Status.ObservedGeneration >= cls.Generation && cls.Status.ReadyReplicas == replicas &&
+			len(pods) == int(replicas) && ready == replicas, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return nil, fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to be ready, %d of %d pods ready",
+			timeout, cls.Namespace, cls.Name, ready, replicas)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// AssertMinReadySecondsHonored waits until all pods controlled by owner are available, and verifies along the way
+// that .status.availableReplicas never counts a pod which has been ready for less than minReadySeconds. The test is
+// skipped for CollaSets, which have no minReadySeconds and count the pods labeled service-available as available.
+func (f *Framework) AssertMinReadySecondsHonored(owner client.Object, minReadySeconds int32, timeout time.Duration) error {
+	if _, ok := owner.(*appsv1alpha1.CollaSet); ok {
+		ginkgo.Skip("CollaSet has no minReadySeconds, its available replicas are the pods labeled service-available")
+	}
+	minReady := time.Duration(minReadySeconds) * time.Second
+	Logf("Waiting up to %v for %s/%s to honor minReadySeconds %d", timeout, owner.GetNamespace(), owner.GetName(), minReadySeconds)
+
+	var available int64
+	var total, ready, readyLongEnough int
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		if available, err = nestedInt64(owner, "status", "availableReplicas"); err != nil {
+			return false, err
+		}
+
+		now := time.Now()
+		total, ready, readyLongEnough = len(pods), 0, 0
+		for i := range pods {
+			if isPodReadyFor(&pods[i], 0, now) {
+				ready++
+			}
+			if isPodReadyFor(&pods[i], minReady, now) {
+				readyLongEnough++
+			}
+		}
+		if int(available) > readyLongEnough {
+			return false, fmt.Errorf("%s/%s reports %d available replicas, but only %d of %d ready pods have been ready for %v",
+				owner.GetNamespace(), owner.GetName(), available, readyLongEnough, ready, minReady)
+		}
+		return total > 0 && readyLongEnough == total && int(available) == total, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", owner)
+		return fmt.Errorf("gave up after waiting %v for %s/%s to be available: pods %d, ready %d, ready for %v %d, available %d",
+			timeout, owner.GetNamespace(), owner.GetName(), total, ready, minReady, readyLongEnough, available)
+	}
+	return err
+}
+
+// AssertScaleBlockedByTransitionRule scales the CollaSet in to targetReplicas and verifies its pods are not
+// deleted within blockDuration while PodTransitionRules block them. It then disables the rules targeting the
+// pods, and waits up to timeout for the CollaSet to reach targetReplicas.
+func (f *Framework) AssertScaleBlockedByTransitionRule(name string, targetReplicas int32, blockDuration, timeout time.Duration) error {
+	cls, err := f.ScaleCollaSet(name, targetReplicas)
+	if err != nil {
+		return err
+	}
+
+	var pods []corev1.Pod
+	Logf("Verifying CollaSet %s/%s is blocked from scaling in for %v", cls.Namespace, name, blockDuration)
+	err = wait.PollImmediate(Poll, blockDuration, func() (bool, error) {
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		if len(pods) <= int(targetReplicas) {
+			return false, fmt.Errorf("CollaSet %s/%s scaled in to %d pods while PodTransitionRules should block it", cls.Namespace, name, len(pods))
+		}
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	podNames := make([]string, len(pods))
+	for i := range pods {
+		podNames[i] = pods[i].Name
+	}
+	if err := f.DisableTransitionRulesForPods(podNames...); err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for CollaSet %s/%s to scale in to %d pods", timeout, cls.Namespace, name, targetReplicas)
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		return len(pods) == int(targetReplicas), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to scale in to %d pods, still %d pods", timeout, cls.Namespace, name, targetReplicas, len(pods))
+	}
+	return err
+}
+
+// DeleteCollaSetAndWait deletes the CollaSet, and waits until the CollaSet and all its pods are gone, as well as its
+// PVCs unless they are retained per the whenDeleted PersistentVolumeClaimRetentionPolicy. On timeout, the remaining
+// dependents are reported, where retained PVCs are distinguished from leaked ones.
+func (f *Framework) DeleteCollaSetAndWait(name string, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	retainPVCs := cls.Spec.ScaleStrategy.PersistentVolumeClaimRetentionPolicy != nil &&
+		cls.Spec.ScaleStrategy.PersistentVolumeClaimRetentionPolicy.WhenDeleted == appsv1alpha1.RetainPersistentVolumeClaimRetentionPolicyType
+
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	if err := f.Client.List(context.TODO(), pvcList, client.InNamespace(cls.Namespace)); err != nil {
+		return err
+	}
+	var pvcNames []string
+	for i := range pvcList.Items {
+		if isOwnedBy(&pvcList.Items[i], cls) {
+			pvcNames = append(pvcNames, pvcList.Items[i].Name)
+		}
+	}
+
+	Logf("Deleting CollaSet %s/%s", cls.Namespace, name)
+	if err := f.Client.Delete(context.TODO(), cls); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	Logf("Waiting up to %v for CollaSet %s/%s and its dependents to be deleted", timeout, cls.Namespace, name)
+	var clsExists bool
+	var remainingPods, remainingPVCs []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if _, err := f.GetCollaSet(name); err == nil {
+			clsExists = true
+		} else if apierrors.IsNotFound(err) {
+			clsExists = false
+		} else {
+			return false, err
+		}
+
+		podList := &corev1.PodList{}
+		if err := f.Client.List(context.TODO(), podList, client.InNamespace(cls.Namespace)); err != nil {
+			return false, err
+		}
+		remainingPods = nil
+		for i := range podList.Items {
+			if isOwnedBy(&podList.Items[i], cls) {
+				remainingPods = append(remainingPods, podList.Items[i].Name)
+			}
+		}
+
+		remainingPVCs = nil
+		for _, pvcName := range pvcNames {
+			pvc := &corev1.PersistentVolumeClaim{}
+			err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: cls.Namespace, Name: pvcName}, pvc)
+			if err == nil {
+				remainingPVCs = append(remainingPVCs, pvcName)
+			} else if !apierrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return !clsExists && len(remainingPods) == 0 && (retainPVCs || len(remainingPVCs) == 0), nil
+	})
+	if retainPVCs && len(remainingPVCs) > 0 {
+		Logf("PVCs of CollaSet %s/%s retained per whenDeleted policy Retain: %v", cls.Namespace, name, remainingPVCs)
+	}
+	if err == wait.ErrWaitTimeout {
+		msg := fmt.Sprintf("gave up after waiting %v for CollaSet %s/%s to be deleted: CollaSet exists %t, leaked pods %v",
+			timeout, cls.Namespace, name, clsExists, remainingPods)
+		if retainPVCs {
+			msg += fmt.Sprintf(", retained PVCs %v", remainingPVCs)
+		} else {
+			msg += fmt.Sprintf(", leaked PVCs %v", remainingPVCs)
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return err
+}
+
+// AssertCollaSetStatusConsistent verifies the replica counts in the status of the CollaSet add up, e.g.
+// readyReplicas <= replicas and availableReplicas <= readyReplicas, to catch status accounting regressions.
+func (f *Framework) AssertCollaSetStatusConsistent(name string) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+
+	status := cls.Status
+	invariants := []struct {
+		lessName  string
+		less      int32
+		greatName string
+		great     int32
+	}{
+		{"scheduledReplicas", status.ScheduledReplicas, "replicas", status.Replicas},
+		{"readyReplicas", status.ReadyReplicas, "replicas", status.Replicas},
+		{"availableReplicas", status.AvailableReplicas, "readyReplicas", status.ReadyReplicas},
+		{"updatedReplicas", status.UpdatedReplicas, "replicas", status.Replicas},
+		{"operatingReplicas", status.OperatingReplicas, "replicas", status.Replicas},
+		{"updatedReadyReplicas", status.UpdatedReadyReplicas, "updatedReplicas", status.UpdatedReplicas},
+		{"updatedReadyReplicas", status.UpdatedReadyReplicas, "readyReplicas", status.ReadyReplicas},
+		{"updatedAvailableReplicas", status.UpdatedAvailableReplicas, "updatedReadyReplicas", status.UpdatedReadyReplicas},
+		{"updatedAvailableReplicas", status.UpdatedAvailableReplicas, "availableReplicas", status.AvailableReplicas},
+	}
+
+	var violations []string
+	for _, inv := range invariants {
+		if inv.less < 0 {
+			violations = append(violations, fmt.Sprintf("%s %d < 0", inv.lessName, inv.less))
+		}
+		if inv.less > inv.great {
+			violations = append(violations, fmt.Sprintf("%s %d > %s %d", inv.lessName, inv.less, inv.greatName, inv.great))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("status of CollaSet %s/%s is inconsistent: %s", cls.Namespace, name, strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+// AssertReadyForCurrentGeneration waits until the CollaSet has observed its current generation, and its
+// updatedReadyReplicas matches the ready pods on the updated revision. One of these pods is spot-checked to run
+// the images of the current template, which catches stale status reported after rapid spec changes.
+func (f *Framework) AssertReadyForCurrentGeneration(name string, timeout time.Duration) error {
+	var cls *appsv1alpha1.CollaSet
+	var updatedReady int
+	var mismatch string
+	Logf("Waiting up to %v for CollaSet %s/%s to be ready for its current generation", timeout, f.Namespace.Name, name)
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if cls, err = f.GetCollaSet(name); err != nil {
+			return false, err
+		}
+		if cls.Status.ObservedGeneration != cls.Generation || cls.Status.UpdatedRevision == "" {
+			return false, nil
+		}
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+
+		updatedReady, mismatch = 0, ""
+		var sample *corev1.Pod
+		for i := range pods {
+			pod := &pods[i]
+			if pod.Labels[appsv1.ControllerRevisionHashLabelKey] != cls.Status.UpdatedRevision {
+				continue
+			}
+			if cond := getPodReadyCondition(pod); cond != nil && cond.Status == corev1.ConditionTrue {
+				updatedReady++
+				if sample == nil {
+					sample = pod
+				}
+			}
+		}
+		if int32(updatedReady) != cls.Status.UpdatedReadyReplicas {
+			return false, nil
+		}
+		if sample == nil {
+			return true, nil
+		}
+
+		images := map[string]string{}
+		for _, c := range sample.Spec.Containers {
+			images[c.Name] = c.Image
+		}
+		for _, c := range cls.Spec.Template.Spec.Containers {
+			if images[c.Name] != c.Image {
+				mismatch = fmt.Sprintf("pod %s on revision %s runs image %q in container %s, template has %q",
+					sample.Name, cls.Status.UpdatedRevision, images[c.Name], c.Name, c.Image)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		msg := fmt.Sprintf("gave up after waiting %v for CollaSet %s/%s to be ready for generation %d: observedGeneration %d, updatedReadyReplicas %d, ready pods on updated revision %d",
+			timeout, f.Namespace.Name, name, cls.Generation, cls.Status.ObservedGeneration, cls.Status.UpdatedReadyReplicas, updatedReady)
+		if mismatch != "" {
+			msg += ", " + mismatch
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return err
+}
+
+// partitionStableDuration is how long a partitioned rollout is verified to stay put once it is reached.
+const partitionStableDuration = 10 * time.Second
+
+// countPodsByRevision counts the pods on the updated revision of the CollaSet, and formats the revision of each pod.
+func countPodsByRevision(pods []corev1.Pod, updatedRevision string) (updated int, breakdown string) {
+	revisions := make([]string, 0, len(pods))
+	for i := range pods {
+		revision := pods[i].Labels[appsv1.ControllerRevisionHashLabelKey]
+		if revision == updatedRevision {
+			updated++
+		}
+		revisions = append(revisions, fmt.Sprintf("%s=%s", pods[i].Name, revision))
+	}
+	return updated, "[" + strings.Join(revisions, ", ") + "]"
+}
+
+// AssertPartitionRollout verifies a CollaSet rolling out by partition updates exactly min(partition, replicas) pods
+// to the updated revision, as partition is the number of pods to update in CollaSet, and keeps the other pods on the
+// old revision. The rollout is then verified to stay stable. The revision of each pod is dumped on mismatch.
+func (f *Framework) AssertPartitionRollout(name string, partition int32, timeout time.Duration) error {
+	var cls *appsv1alpha1.CollaSet
+	var pods []corev1.Pod
+	var expected, updated int
+	var breakdown string
+	check := func() (bool, error) {
+		var err error
+		if cls, err = f.GetCollaSet(name); err != nil {
+			return false, err
+		}
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		replicas := int32(0)
+		if cls.Spec.Replicas != nil {
+			replicas = *cls.Spec.Replicas
+		}
+		expected = int(partition)
+		if partition > replicas {
+			expected = int(replicas)
+		}
+		updated, breakdown = countPodsByRevision(pods, cls.Status.UpdatedRevision)
+		return len(pods) == int(replicas) && updated == expected, nil
+	}
+
+	Logf("Waiting up to %v for CollaSet %s/%s to update %d pods by partition", timeout, f.Namespace.Name, name, partition)
+	err := wait.PollImmediate(Poll, timeout, check)
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		Logf("Revisions of pods of CollaSet %s/%s, updated revision %s: %s", f.Namespace.Name, name, cls.Status.UpdatedRevision, breakdown)
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to update %d pods by partition, %d of %d pods updated",
+			timeout, f.Namespace.Name, name, expected, updated, len(pods))
+	}
+	if err != nil {
+		return err
+	}
+
+	Logf("Verifying CollaSet %s/%s stays at partition %d for %v", f.Namespace.Name, name, partition, partitionStableDuration)
+	err = wait.PollImmediate(Poll, partitionStableDuration, func() (bool, error) {
+		reached, err := check()
+		if err != nil {
+			return false, err
+		}
+		if !reached {
+			Logf("Revisions of pods of CollaSet %s/%s, updated revision %s: %s", f.Namespace.Name, name, cls.Status.UpdatedRevision, breakdown)
+			return false, fmt.Errorf("CollaSet %s/%s is not stable at partition %d, %d of %d pods updated, expected %d",
+				f.Namespace.Name, name, partition, updated, len(pods), expected)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// containerStatusOf returns the status of the named container of the pod, or nil if it is not reported.
+func containerStatusOf(pod *corev1.Pod, containerName string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == containerName {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// AssertInPlaceUpdatePreferred updates only the image of the container of the CollaSet, and verifies its pods are
+// updated in place, i.e. they keep their UIDs while the container runs the new image and is restarted. It fails with
+// the UIDs before and after as soon as any pod is recreated.
+func (f *Framework) AssertInPlaceUpdatePreferred(name, containerName, newImage string, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	pods, err := f.listOwnedPods(cls)
+	if err != nil {
+		return err
+	}
+	before := map[types.UID]int32{}
+	var beforeUIDs []string
+	for i := range pods {
+		status := containerStatusOf(&pods[i], containerName)
+		if status == nil {
+			return fmt.Errorf("pod %s/%s reports no status of container %s", pods[i].Namespace, pods[i].Name, containerName)
+		}
+		before[pods[i].UID] = status.RestartCount
+		beforeUIDs = append(beforeUIDs, fmt.Sprintf("%s=%s", pods[i].Name, pods[i].UID))
+	}
+
+	Logf("Updating image of container %s of CollaSet %s/%s to %s", containerName, f.Namespace.Name, name, newImage)
+	_, err = f.UpdateCollaSet(name, func(cls *appsv1alpha1.CollaSet) {
+		for i := range cls.Spec.Template.Spec.Containers {
+			if cls.Spec.Template.Spec.Containers[i].Name == containerName {
+				cls.Spec.Template.Spec.Containers[i].Image = newImage
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for %d pods of CollaSet %s/%s to be updated in place", timeout, len(before), f.Namespace.Name, name)
+	var updated int
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+		var afterUIDs []string
+		recreated := false
+		for i := range pods {
+			afterUIDs = append(afterUIDs, fmt.Sprintf("%s=%s", pods[i].Name, pods[i].UID))
+			if _, ok := before[pods[i].UID]; !ok {
+				recreated = true
+			}
+		}
+		if recreated {
+			return false, fmt.Errorf("pods of CollaSet %s/%s are recreated instead of updated in place, UIDs before %v, after %v",
+				f.Namespace.Name, name, beforeUIDs, afterUIDs)
+		}
+
+		updated = 0
+		for i := range pods {
+			pod := &pods[i]
+			status := containerStatusOf(pod, containerName)
+			if status == nil || !status.Ready || status.RestartCount <= before[pod.UID] {
+				continue
+			}
+			for _, c := range pod.Spec.Containers {
+				if c.Name == containerName && c.Image == newImage {
+					updated++
+				}
+			}
+		}
+		return len(pods) == len(before) && updated == len(before), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for pods of CollaSet %s/%s to be updated in place to image %s, %d of %d pods updated",
+			timeout, f.Namespace.Name, name, newImage, updated, len(before))
+	}
+	return err
+}
+
+// AssertInPlaceOnlyBlocks is meant to verify that a CollaSet updating its pods InPlaceOnly blocks a mutation which
+// cannot be rolled out in place instead of recreating its pods. It always skips the test: the CollaSet controller
+// implements InPlaceOnly by the InPlaceIfPossible updater, which falls back to recreating the pods.
+func (f *Framework) AssertInPlaceOnlyBlocks(name string, incompatibleMutation func(*appsv1alpha1.CollaSet), timeout time.Duration) error {
+	ginkgo.Skip(fmt.Sprintf("CollaSet %s/%s: InPlaceOnly is implemented as InPlaceIfPossible, which recreates pods it cannot update in place",
+		f.Namespace.Name, name))
+	return nil
+}
+
+// resourceContextName returns the name of the ResourceContext of the CollaSet, which is its scaleStrategy.context
+// or the name of the CollaSet itself.
+func resourceContextName(cls *appsv1alpha1.CollaSet) string {
+	if cls.Spec.ScaleStrategy.Context != "" {
+		return cls.Spec.ScaleStrategy.Context
+	}
+	return cls.Name
+}
+
+// ScaleInSpecificPods scales in the CollaSet by the named pods, which are marked for scale-in by beginning the
+// scale-in PodOpsLifecycle of the CollaSet on them, so that the CollaSet deletes them in preference to any other
+// pod. It verifies exactly the named pods are deleted, and reports the pods actually deleted on mismatch.
+func (f *Framework) ScaleInSpecificPods(name string, podNames []string, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	pods, err := f.listOwnedPods(cls)
+	if err != nil {
+		return err
+	}
+	before := sets.NewString()
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil {
+			before.Insert(pods[i].Name)
+		}
+	}
+	targets := sets.NewString(podNames...)
+	if missing := targets.Difference(before); missing.Len() > 0 {
+		return fmt.Errorf("pods %v are not controlled by CollaSet %s/%s", missing.List(), f.Namespace.Name, name)
+	}
+
+	for _, podName := range podNames {
+		Logf("Marking pod %s/%s to scale in", f.Namespace.Name, podName)
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			pod := &corev1.Pod{}
+			if err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: f.Namespace.Name, Name: podName}, pod); err != nil {
+				return err
+			}
+			_, err := podopslifecycle.Begin(f.Client, collasetutils.ScaleInOpsLifecycleAdapter, pod)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	replicas := int32(before.Len() - targets.Len())
+	if _, err := f.ScaleCollaSet(name, replicas); err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for CollaSet %s/%s to scale in pods %v", timeout, f.Namespace.Name, name, podNames)
+	var deleted sets.String
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+		alive := sets.NewString()
+		for i := range pods {
+			if pods[i].DeletionTimestamp == nil {
+				alive.Insert(pods[i].Name)
+			}
+		}
+		deleted = before.Difference(alive)
+		if unexpected := deleted.Difference(targets); unexpected.Len() > 0 {
+			return false, fmt.Errorf("CollaSet %s/%s scaled in pods %v, expected %v", f.Namespace.Name, name, deleted.List(), targets.List())
+		}
+		return alive.Len() == int(replicas) && deleted.Equal(targets), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to scale in pods %v, deleted pods %v",
+			timeout, f.Namespace.Name, name, targets.List(), deleted.List())
+	}
+	return err
+}
+
+// WaitForResourceContextEntries waits until the ResourceContext of the CollaSet, which is named by its
+// scaleStrategy.context or after the CollaSet itself, has expected entries owned by the CollaSet.
+func (f *Framework) WaitForResourceContextEntries(collaSetName string, expected int, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(collaSetName)
+	if err != nil {
+		return err
+	}
+	contextName := resourceContextName(cls)
+	Logf("Waiting up to %v for ResourceContext %s/%s to have %d entries of CollaSet %s", timeout, cls.Namespace, contextName, expected, collaSetName)
+
+	var owned []int
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		resourceContext := &appsv1alpha1.ResourceContext{}
+		err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: cls.Namespace, Name: contextName}, resourceContext)
+		if apierrors.IsNotFound(err) {
+			owned = nil
+			return expected == 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		owned = nil
+		for i := range resourceContext.Spec.Contexts {
+			if resourceContext.Spec.Contexts[i].Contains(podcontext.OwnerContextKey, collaSetName) {
+				owned = append(owned, resourceContext.Spec.Contexts[i].ID)
+			}
+		}
+		return len(owned) == expected, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for ResourceContext %s/%s to have %d entries of CollaSet %s, got IDs %v",
+			timeout, cls.Namespace, contextName, expected, collaSetName, owned)
+	}
+	return err
+}
+
+// AssertResourceContextReleased waits until the CollaSet settles, and its ResourceContext has no entry owned by it
+// whose ID is not carried by any of its pods, i.e. every ID reserved for a completed scale operation is released.
+// The leftover entries are reported on timeout.
+func (f *Framework) AssertResourceContextReleased(collaSetName string, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(collaSetName)
+	if err != nil {
+		return err
+	}
+	contextName := resourceContextName(cls)
+	Logf("Waiting up to %v for ResourceContext %s/%s to release the IDs of CollaSet %s", timeout, cls.Namespace, contextName, collaSetName)
+
+	var leftover []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(0)
+		if cls.Spec.Replicas != nil {
+			replicas = *cls.Spec.Replicas
+		}
+		if cls.Status.ObservedGeneration < cls.Generation || cls.Status.Replicas != replicas || len(pods) != int(replicas) {
+			return false, nil
+		}
+		podIDs := map[string]struct{}{}
+		for i := range pods {
+			podIDs[pods[i].Labels[appsv1alpha1.PodInstanceIDLabelKey]] = struct{}{}
+		}
+
+		resourceContext := &appsv1alpha1.ResourceContext{}
+		err = f.Client.Get(context.TODO(), types.NamespacedName{Namespace: cls.Namespace, Name: contextName}, resourceContext)
+		if apierrors.IsNotFound(err) {
+			leftover = nil
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		leftover = nil
+		for i := range resourceContext.Spec.Contexts {
+			detail := &resourceContext.Spec.Contexts[i]
+			if !detail.Contains(podcontext.OwnerContextKey, collaSetName) {
+				continue
+			}
+			if _, ok := podIDs[strconv.Itoa(detail.ID)]; !ok {
+				leftover = append(leftover, fmt.Sprintf("%d%v", detail.ID, detail.Data))
+			}
+		}
+		return len(leftover) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for ResourceContext %s/%s to release the IDs of CollaSet %s, leftover entries %v",
+			timeout, cls.Namespace, contextName, collaSetName, leftover)
+	}
+	return err
+}
+
+// AssertPodContextBinding waits until the ResourceContext of the CollaSet controlling the pod has exactly one entry
+// whose ID is the instance-id of the pod, and that entry is owned by the CollaSet. The entries of the ID are
+// reported on timeout, so that both missing or foreign and duplicate entries are visible.
+func (f *Framework) AssertPodContextBinding(podName string, timeout time.Duration) error {
+	var entries []string
+	var instanceID string
+	var contextName string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		ref := metav1.GetControllerOf(pod)
+		if ref == nil || ref.Kind != "CollaSet" {
+			return false, fmt.Errorf("pod %s/%s is not controlled by a CollaSet", pod.Namespace, podName)
+		}
+		cls, err := f.GetCollaSet(ref.Name)
+		if err != nil {
+			return false, err
+		}
+		contextName = resourceContextName(cls)
+		instanceID = pod.Labels[appsv1alpha1.PodInstanceIDLabelKey]
+		if instanceID == "" {
+			return false, nil
+		}
+
+		resourceContext := &appsv1alpha1.ResourceContext{}
+		err = f.Client.Get(context.TODO(), types.NamespacedName{Namespace: cls.Namespace, Name: contextName}, resourceContext)
+		if apierrors.IsNotFound(err) {
+			entries = nil
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		entries = nil
+		owned := 0
+		for i := range resourceContext.Spec.Contexts {
+			detail := &resourceContext.Spec.Contexts[i]
+			if strconv.Itoa(detail.ID) != instanceID {
+				continue
+			}
+			entries = append(entries, fmt.Sprintf("%d%v", detail.ID, detail.Data))
+			if detail.Contains(podcontext.OwnerContextKey, cls.Name) {
+				owned++
+			}
+		}
+		return len(entries) == 1 && owned == 1, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for ResourceContext %s/%s to bind pod %s with instance-id %q, entries of the ID %v",
+			timeout, f.Namespace.Name, contextName, podName, instanceID, entries)
+	}
+	return err
+}
+
+// AssertScaleToZeroRoundTrip scales the CollaSet to zero, waits until its pods are gone and its ResourceContext
+// entries are released, then scales it up to up replicas and verifies new ready pods, none of which was seen before,
+// are bound to the ResourceContext. Each phase waits up to timeout, and leftover entries are reported.
+func (f *Framework) AssertScaleToZeroRoundTrip(name string, up int32, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	pods, err := f.listOwnedPods(cls)
+	if err != nil {
+		return err
+	}
+	formerUIDs := sets.NewString()
+	for i := range pods {
+		formerUIDs.Insert(string(pods[i].UID))
+	}
+
+	Logf("Scaling CollaSet %s/%s to zero", f.Namespace.Name, name)
+	if _, err := f.ScaleCollaSet(name, 0); err != nil {
+		return err
+	}
+	var remaining []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for i := range pods {
+			formerUIDs.Insert(string(pods[i].UID))
+			remaining = append(remaining, pods[i].Name)
+		}
+		return len(remaining) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pods of CollaSet %s/%s to be gone, remaining %v", timeout, f.Namespace.Name, name, remaining)
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.WaitForResourceContextEntries(name, 0, timeout); err != nil {
+		return err
+	}
+
+	Logf("Scaling CollaSet %s/%s back up to %d", f.Namespace.Name, name, up)
+	if _, err := f.ScaleCollaSet(name, up); err != nil {
+		return err
+	}
+	var ready int32
+	var reused []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		ready, reused = 0, nil
+		for i := range pods {
+			if formerUIDs.Has(string(pods[i].UID)) {
+				reused = append(reused, pods[i].Name)
+			}
+			cond := getPodReadyCondition(&pods[i])
+			if pods[i].DeletionTimestamp == nil && cond != nil && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+		if len(reused) > 0 {
+			return false, fmt.Errorf("pods %v of CollaSet %s/%s survived scaling to zero", reused, f.Namespace.Name, name)
+		}
+		return len(pods) == int(up) && ready == up, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to scale back up to %d, %d pods ready", timeout, f.Namespace.Name, name, up, ready)
+	}
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		if err := f.AssertPodContextBinding(pods[i].Name, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackCollaSet reverts the template of the CollaSet to the one recorded by the ControllerRevision toRevision,
+// and waits until all its pods are on toRevision and its status settles. The revision of each pod is reported on
+// timeout, so that partial rollbacks are visible.
+func (f *Framework) RollbackCollaSet(name, toRevision string, timeout time.Duration) error {
+	revision := &appsv1.ControllerRevision{}
+	if err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: f.Namespace.Name, Name: toRevision}, revision); err != nil {
+		return err
+	}
+
+	Logf("Rolling back CollaSet %s/%s to revision %s", f.Namespace.Name, name, toRevision)
+	var applyErr error
+	cls, err := f.UpdateCollaSet(name, func(cls *appsv1alpha1.CollaSet) {
+		// the revision data is a strategic merge patch replacing the template of the CollaSet
+		var current, patched []byte
+		if current, applyErr = json.Marshal(cls); applyErr != nil {
+			return
+		}
+		if patched, applyErr = strategicpatch.StrategicMergePatch(current, revision.Data.Raw, cls); applyErr != nil {
+			return
+		}
+		rolledBack := &appsv1alpha1.CollaSet{}
+		if applyErr = json.Unmarshal(patched, rolledBack); applyErr != nil {
+			return
+		}
+		cls.Spec = rolledBack.Spec
+	})
+	if err != nil {
+		return err
+	}
+	if applyErr != nil {
+		return fmt.Errorf("fail to apply revision %s to CollaSet %s/%s: %v", toRevision, f.Namespace.Name, name, applyErr)
+	}
+
+	Logf("Waiting up to %v for CollaSet %s/%s to roll back to revision %s", timeout, cls.Namespace, name, toRevision)
+	var pods []corev1.Pod
+	var updated int
+	var breakdown string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if cls, err = f.GetCollaSet(name); err != nil {
+			return false, err
+		}
+		if pods, err = f.listOwnedPods(cls); err != nil {
+			return false, err
+		}
+		updated, breakdown = countPodsByRevision(pods, toRevision)
+		return cls.Status.ObservedGeneration == cls.Generation &&
+			cls.Status.UpdatedRevision == toRevision &&
+			cls.Spec.Replicas != nil && int(*cls.Spec.Replicas) == len(pods) &&
+			updated == len(pods), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to roll back to revision %s, %d of %d pods rolled back: %s",
+			timeout, cls.Namespace, name, toRevision, updated, len(pods), breakdown)
+	}
+	return err
+}
+
+// CreateOrphanPodFor creates a pod without an owner from the template of the CollaSet, so that it matches the
+// selector of the CollaSet, e.g. to verify the CollaSet leaves it alone. The pod is deleted in AfterEach.
+func (f *Framework) CreateOrphanPodFor(cls *appsv1alpha1.CollaSet, podName string) (*corev1.Pod, error) {
+	template := cls.Spec.Template.DeepCopy()
+	pod := &corev1.Pod{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	pod.Namespace = cls.Namespace
+	pod.Name = podName
+	pod.GenerateName = ""
+	pod.OwnerReferences = nil
+
+	Logf("Creating orphan pod %s/%s matching CollaSet %s", pod.Namespace, podName, cls.Name)
+	if err := f.Client.Create(context.TODO(), pod); err != nil {
+		return nil, err
+	}
+	f.TrackObject(pod)
+	f.addCleanup(func() {
+		if err := f.Client.Delete(context.TODO(), pod); err != nil && !apierrors.IsNotFound(err) {
+			Logf("Failed to delete orphan pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	})
+	return pod, nil
+}
+
+// AssertAdoptsExistingPods is meant to verify that a CollaSet created over preexisting pods matching its selector
+// adopts them instead of recreating them. It always skips the test: CollaSet lists pods only through their
+// controller ownerReference and never adopts orphan pods.
+func (f *Framework) AssertAdoptsExistingPods(csFactory func() *appsv1alpha1.CollaSet, preexisting []*corev1.Pod, timeout time.Duration) error {
+	ginkgo.Skip("CollaSet never adopts preexisting pods, it only manages the pods it created")
+	return nil
+}
+
+// AssertControllerIgnoresUnownedPods creates, for each CollaSet in the framework namespace, a pod matching its
+// selector but controlled by a ConfigMap, and verifies throughout the timeout that no CollaSet adopts or mutates
+// the metadata of these pods. The unexpected ownership or mutation is reported.
+func (f *Framework) AssertControllerIgnoresUnownedPods(timeout time.Duration) error {
+	clsList := &appsv1alpha1.CollaSetList{}
+	if err := f.Client.List(context.TODO(), clsList, client.InNamespace(f.Namespace.Name)); err != nil {
+		return err
+	}
+	if len(clsList.Items) == 0 {
+		return fmt.Errorf("no CollaSet in namespace %s", f.Namespace.Name)
+	}
+
+	foreignOwner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: f.Namespace.Name, GenerateName: "foreign-owner-"}}
+	if err := f.Client.Create(context.TODO(), foreignOwner); err != nil {
+		return err
+	}
+	isController := true
+	foreignRef := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       foreignOwner.Name,
+		UID:        foreignOwner.UID,
+		Controller: &isController,
+	}
+
+	created := map[string]*corev1.Pod{}
+	for i := range clsList.Items {
+		cls := &clsList.Items[i]
+		template := cls.Spec.Template.DeepCopy()
+		pod := &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+		pod.Namespace = f.Namespace.Name
+		pod.Name = ""
+		pod.GenerateName = cls.Name + "-unowned-"
+		pod.OwnerReferences = []metav1.OwnerReference{foreignRef}
+		if err := f.Client.Create(context.TODO(), pod); err != nil {
+			return err
+		}
+		Logf("Created pod %s/%s matching CollaSet %s, controlled by ConfigMap %s", pod.Namespace, pod.Name, cls.Name, foreignOwner.Name)
+		created[pod.Name] = pod
+	}
+
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		for name, orig := range created {
+			pod := &corev1.Pod{}
+			if err := f.Client.Get(context.TODO(), types.NamespacedName{Namespace: f.Namespace.Name, Name: name}, pod); err != nil {
+				if apierrors.IsNotFound(err) {
+					return false, fmt.Errorf("unowned pod %s/%s is deleted", f.Namespace.Name, name)
+				}
+				return false, err
+			}
+			if ref := metav1.GetControllerOf(pod); ref == nil || ref.UID != foreignOwner.UID {
+				return false, fmt.Errorf("unowned pod %s/%s is taken over, ownerReferences %v", pod.Namespace, name, pod.OwnerReferences)
+			}
+			if !equality.Semantic.DeepEqual(pod.OwnerReferences, orig.OwnerReferences) ||
+				!equality.Semantic.DeepEqual(pod.Labels, orig.Labels) ||
+				!equality.Semantic.DeepEqual(pod.Annotations, orig.Annotations) ||
+				!equality.Semantic.DeepEqual(pod.Finalizers, orig.Finalizers) {
+				f.LogfObject("Mutated", pod)
+				return false, fmt.Errorf("metadata of unowned pod %s/%s is mutated: ownerReferences %v, labels %v, annotations %v, finalizers %v",
+					pod.Namespace, name, pod.OwnerReferences, pod.Labels, pod.Annotations, pod.Finalizers)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// AssertSelectorChangeHandled changes the template labels of the CollaSet, and its selector accordingly, to
+// newLabels. It passes if the change is rejected. Otherwise it waits until the CollaSet controls its replicas of
+// pods all matching newLabels, and none of its former pods is left running without a controller.
+func (f *Framework) AssertSelectorChangeHandled(name string, newLabels map[string]string, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	pods, err := f.listOwnedPods(cls)
+	if err != nil {
+		return err
+	}
+	formerPods := sets.NewString()
+	for i := range pods {
+		formerPods.Insert(pods[i].Name)
+	}
+
+	Logf("Changing template labels and selector of CollaSet %s/%s to %v", f.Namespace.Name, name, newLabels)
+	cls, err = f.UpdateCollaSet(name, func(cls *appsv1alpha1.CollaSet) {
+		cls.Spec.Template.Labels = newLabels
+		cls.Spec.Selector = &metav1.LabelSelector{MatchLabels: newLabels}
+	})
+	if apierrors.IsInvalid(err) || apierrors.IsForbidden(err) || apierrors.IsBadRequest(err) {
+		Logf("Selector change of CollaSet %s/%s is rejected: %v", f.Namespace.Name, name, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	selector := labels.SelectorFromSet(newLabels)
+	var mismatched, orphaned []string
+	var owned int
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		if err := f.refreshObject(cls); err != nil {
+			return false, err
+		}
+		mismatched, orphaned, owned = nil, nil, 0
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			if metav1.IsControlledBy(pod, cls) {
+				owned++
+				if !selector.Matches(labels.Set(pod.Labels)) {
+					mismatched = append(mismatched, pod.Name)
+				}
+			} else if formerPods.Has(pod.Name) && metav1.GetControllerOf(pod) == nil {
+				orphaned = append(orphaned, pod.Name)
+			}
+		}
+		replicas := int32(0)
+		if cls.Spec.Replicas != nil {
+			replicas = *cls.Spec.Replicas
+		}
+		return owned == int(replicas) && len(mismatched) == 0 && len(orphaned) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", cls)
+		return fmt.Errorf("gave up after waiting %v for CollaSet %s/%s to handle selector change, %d pods owned, pods not matching %v, orphaned pods %v",
+			timeout, f.Namespace.Name, name, owned, mismatched, orphaned)
+	}
+	return err
+}
+
+// describePVCs formats the PVCs together with their ownerReferences.
+func describePVCs(pvcs []corev1.PersistentVolumeClaim) string {
+	var descs []string
+	for _, pvc := range pvcs {
+		var owners []string
+		for _, ref := range pvc.OwnerReferences {
+			owners = append(owners, ref.Kind+"/"+ref.Name)
+		}
+		descs = append(descs, fmt.Sprintf("%s(owners=%v)", pvc.Name, owners))
+	}
+	return "[" + strings.Join(descs, ", ") + "]"
+}
+
+// AssertPVCRetention verifies the PVCs of the instances scaled in from the owner, i.e. those labeled with an
+// instance-id no longer used by any of its pods, honor the whenScaled PersistentVolumeClaimRetentionPolicy:
+// they are deleted for Delete, and retained for Retain. Retained PVCs stay owned by the owner, so that they
+// can be reused on scale-out; their ownerReferences are only released once the owner is deleted.
+func (f *Framework) AssertPVCRetention(owner client.Object, policy string, timeout time.Duration) error {
+	if policy != string(appsv1alpha1.DeletePersistentVolumeClaimRetentionPolicyType) &&
+		policy != string(appsv1alpha1.RetainPersistentVolumeClaimRetentionPolicyType) {
+		return fmt.Errorf("unknown PersistentVolumeClaimRetentionPolicy %q", policy)
+	}
+
+	Logf("Waiting up to %v for PVCs scaled in from %s/%s to be handled per policy %s", timeout, owner.GetNamespace(), owner.GetName(), policy)
+	var scaledIn []corev1.PersistentVolumeClaim
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		inUse := map[string]bool{}
+		for i := range pods {
+			// pods being scaled in still hold their PVCs
+			if pods[i].DeletionTimestamp != nil {
+				return false, nil
+			}
+			inUse[pods[i].Labels[appsv1alpha1.PodInstanceIDLabelKey]] = true
+		}
+
+		pvcList := &corev1.PersistentVolumeClaimList{}
+		if err := f.Client.List(context.TODO(), pvcList, client.InNamespace(owner.GetNamespace())); err != nil {
+			return false, err
+		}
+		scaledIn = nil
+		for i := range pvcList.Items {
+			pvc := &pvcList.Items[i]
+			id, ok := pvc.Labels[appsv1alpha1.PodInstanceIDLabelKey]
+			if ok && isOwnedBy(pvc, owner) && !inUse[id] {
+				scaledIn = append(scaledIn, *pvc)
+			}
+		}
+
+		if policy == string(appsv1alpha1.DeletePersistentVolumeClaimRetentionPolicyType) {
+			return len(scaledIn) == 0, nil
+		}
+		for i := range scaledIn {
+			if scaledIn[i].DeletionTimestamp != nil {
+				return false, fmt.Errorf("PVC %s/%s is being deleted despite policy %s", scaledIn[i].Namespace, scaledIn[i].Name, policy)
+			}
+		}
+		return len(scaledIn) > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for PVCs scaled in from %s/%s to be handled per policy %s, scaled-in PVCs %s",
+			timeout, owner.GetNamespace(), owner.GetName(), policy, describePVCs(scaledIn))
+	}
+	if err != nil {
+		return fmt.Errorf("%v, scaled-in PVCs %s", err, describePVCs(scaledIn))
+	}
+	return nil
+}
+
+// AssertNoRevisionCollisionErrors waits until the rollout of the owner completes, i.e. it has observed its current
+// generation and its currentRevision catches up with its updatedRevision, while logging every increment of
+// status.collisionCount, so that revision hash collisions are verified to be disambiguated rather than block it.
+func (f *Framework) AssertNoRevisionCollisionErrors(owner client.Object, timeout time.Duration) error {
+	if err := f.refreshObject(owner); err != nil {
+		return err
+	}
+	initialCollisions, err := nestedInt64(owner, "status", "collisionCount")
+	if err != nil {
+		return err
+	}
+	collisions := initialCollisions
+
+	Logf("Waiting up to %v for rollout of %s/%s to complete, collisionCount %d", timeout, owner.GetNamespace(), owner.GetName(), collisions)
+	var currentRevision, updatedRevision string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if err := f.refreshObject(owner); err != nil {
+			return false, err
+		}
+		content, err := toUnstructured(owner)
+		if err != nil {
+			return false, err
+		}
+		count, _, err := unstructured.NestedInt64(content, "status", "collisionCount")
+		if err != nil {
+			return false, err
+		}
+		if count < collisions {
+			return false, fmt.Errorf("collisionCount of %s/%s decreases from %d to %d", owner.GetNamespace(), owner.GetName(), collisions, count)
+		}
+		if count > collisions {
+			Logf("collisionCount of %s/%s increases from %d to %d", owner.GetNamespace(), owner.GetName(), collisions, count)
+			collisions = count
+		}
+
+		observedGeneration, _, err := unstructured.NestedInt64(content, "status", "observedGeneration")
+		if err != nil {
+			return false, err
+		}
+		currentRevision, _, _ = unstructured.NestedString(content, "status", "currentRevision")
+		updatedRevision, _, _ = unstructured.NestedString(content, "status", "updatedRevision")
+		return observedGeneration == owner.GetGeneration() && updatedRevision != "" && currentRevision == updatedRevision, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", owner)
+		return fmt.Errorf("gave up after waiting %v for rollout of %s/%s to complete after %d revision collisions: currentRevision %s, updatedRevision %s",
+			timeout, owner.GetNamespace(), owner.GetName(), collisions-initialCollisions, currentRevision, updatedRevision)
+	}
+	if err == nil && collisions > initialCollisions {
+		Logf("Rollout of %s/%s completed after %d revision collisions", owner.GetNamespace(), owner.GetName(), collisions-initialCollisions)
+	}
+	return err
+}
+
+// AssertNoUnexpectedRollout records the updatedRevision of the CollaSet, runs duringSetup, e.g. a metadata-only
+// change such as an annotation, and verifies the updatedRevision does not change throughout the timeout, so that
+// no-op changes are not treated as rollouts.
+func (f *Framework) AssertNoUnexpectedRollout(name string, duringSetup func() error, timeout time.Duration) error {
+	cls, err := f.GetCollaSet(name)
+	if err != nil {
+		return err
+	}
+	revision := cls.Status.UpdatedRevision
+	if revision == "" {
+		return fmt.Errorf("CollaSet %s/%s has no updatedRevision yet", f.Namespace.Name, name)
+	}
+
+	if err := duringSetup(); err != nil {
+		return err
+	}
+
+	Logf("Verifying CollaSet %s/%s stays at revision %s for %v", f.Namespace.Name, name, revision, timeout)
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if cls, err = f.GetCollaSet(name); err != nil {
+			return false, err
+		}
+		if cls.Status.UpdatedRevision != revision {
+			f.LogfObject("Rolled out", cls)
+			return false, fmt.Errorf("CollaSet %s/%s unexpectedly rolls out from revision %s to %s at generation %d",
+				f.Namespace.Name, name, revision, cls.Status.UpdatedRevision, cls.Generation)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// AssertNoStaleOwnedResources waits until none of the objects of the given resources in the namespace of the owner,
+// which are owned by it and labeled with an instance-id, refer to an instance-id no longer used by any of its pods,
+// so that per-pod resources are verified to be garbage collected after scale-in. Stale objects are reported per
+// resource on timeout.
+func (f *Framework) AssertNoStaleOwnedResources(owner client.Object, gvrs []schema.GroupVersionResource, timeout time.Duration) error {
+	Logf("Waiting up to %v for stale resources of %s/%s to be garbage collected", timeout, owner.GetNamespace(), owner.GetName())
+	var stale map[string][]string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		inUse := map[string]bool{}
+		for i := range pods {
+			inUse[pods[i].Labels[appsv1alpha1.PodInstanceIDLabelKey]] = true
+		}
+
+		stale = map[string][]string{}
+		for _, gvr := range gvrs {
+			list, err := f.DynamicClient.Resource(gvr).Namespace(owner.GetNamespace()).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return false, err
+			}
+			for i := range list.Items {
+				obj := &list.Items[i]
+				id, ok := obj.GetLabels()[appsv1alpha1.PodInstanceIDLabelKey]
+				if ok && isOwnedBy(obj, owner) && !inUse[id] {
+					stale[gvr.Resource] = append(stale[gvr.Resource], fmt.Sprintf("%s(instance-id=%s)", obj.GetName(), id))
+				}
+			}
+		}
+		return len(stale) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		var msgs []string
+		for _, gvr := range gvrs {
+			if objs, ok := stale[gvr.Resource]; ok {
+				msgs = append(msgs, fmt.Sprintf("%s: %v", gvr.String(), objs))
+			}
+		}
+		return fmt.Errorf("gave up after waiting %v for stale resources of %s/%s to be garbage collected: [%s]",
+			timeout, owner.GetNamespace(), owner.GetName(), strings.Join(msgs, "; "))
+	}
+	return err
+}
+
+// AssertNoDuplicateInstanceIDs lists the pods controlled by owner throughout the timeout, and fails if two live pods
+// ever share an instance-id label, reporting the colliding pods with their creation timestamps. If duringRestart is
+// true, the controller-manager leader is deleted a quarter into the timeout to stress the deduplication on restart.
+func (f *Framework) AssertNoDuplicateInstanceIDs(owner client.Object, duringRestart bool, timeout time.Duration) error {
+	Logf("Monitoring instance ids of pods of %s/%s for %v", owner.GetNamespace(), owner.GetName(), timeout)
+	start := time.Now()
+	restarted := !duringRestart
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if !restarted && time.Since(start) >= timeout/4 {
+			leader, err := f.GetControllerLeaderPod()
+			if err != nil {
+				return false, err
+			}
+			Logf("Restarting the controller-manager by deleting leader pod %s/%s", leader.Namespace, leader.Name)
+			if err := f.ClientSet.CoreV1().Pods(leader.Namespace).Delete(context.TODO(), leader.Name, metav1.DeleteOptions{}); err != nil {
+				return false, err
+			}
+			restarted = true
+		}
+
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		byID := map[string]*corev1.Pod{}
+		for i := range pods {
+			pod := &pods[i]
+			id, ok := pod.Labels[appsv1alpha1.PodInstanceIDLabelKey]
+			if !ok || pod.DeletionTimestamp != nil {
+				continue
+			}
+			if other, ok := byID[id]; ok {
+				return false, fmt.Errorf("observed at %s: pods %s (created at %s) and %s (created at %s) of %s/%s share instance id %s",
+					time.Now().Format(time.RFC3339Nano), other.Name, other.CreationTimestamp.Format(time.RFC3339),
+					pod.Name, pod.CreationTimestamp.Format(time.RFC3339), owner.GetNamespace(), owner.GetName(), id)
+			}
+			byID[id] = pod
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
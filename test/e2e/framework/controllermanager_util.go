@@ -0,0 +1,460 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// leaderPodName returns the name of the pod holding the leader election lease, whose holder identity is
+// <hostname>_<uuid>, where the hostname is the pod name.
+func leaderPodName(identity string) string {
+	return strings.Split(identity, "_")[0]
+}
+
+// GetControllerLeaderPod returns the controller-manager pod holding the leader election lease, i.e. the pod which
+// actually reconciles.
+func (f *Framework) GetControllerLeaderPod() (*corev1.Pod, error) {
+	ns := TestContext.ControllerManagerNamespace
+	lease, err := f.ClientSet.CoordinationV1().Leases(ns).Get(context.TODO(), TestContext.ControllerManagerLeaderElectionID, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get leader election lease %s/%s: %v", ns, TestContext.ControllerManagerLeaderElectionID, err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return nil, fmt.Errorf("leader election lease %s/%s is not held", ns, lease.Name)
+	}
+	pod, err := f.ClientSet.CoreV1().Pods(ns).Get(context.TODO(), leaderPodName(*lease.Spec.HolderIdentity), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get controller-manager pod of leader %s: %v", *lease.Spec.HolderIdentity, err)
+	}
+	return pod, nil
+}
+
+// checkControllerHealthy verifies all the controller-manager pods are ready, the leader election lease is held by
+// one of them, and the leader passes its /healthz probe.
+func (f *Framework) checkControllerHealthy() error {
+	ns := TestContext.ControllerManagerNamespace
+	podList, err := f.ClientSet.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: TestContext.ControllerManagerSelector,
+	})
+	if err != nil {
+		return err
+	}
+	if len(podList.Items) == 0 {
+		return fmt.Errorf("no controller-manager pod in namespace %s with selector %s", ns, TestContext.ControllerManagerSelector)
+	}
+	pods := map[string]*corev1.Pod{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if cond := getPodReadyCondition(pod); cond == nil || cond.Status != corev1.ConditionTrue {
+			return fmt.Errorf("controller-manager pod %s/%s is not ready", ns, pod.Name)
+		}
+		pods[pod.Name] = pod
+	}
+
+	lease, err := f.ClientSet.CoordinationV1().Leases(ns).Get(context.TODO(), TestContext.ControllerManagerLeaderElectionID, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("fail to get leader election lease %s/%s: %v", ns, TestContext.ControllerManagerLeaderElectionID, err)
+	}
+	if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return fmt.Errorf("leader election lease %s/%s is not held", ns, lease.Name)
+	}
+	if lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(time.Now()) {
+		return fmt.Errorf("leader election lease %s/%s held by %s expired", ns, lease.Name, *lease.Spec.HolderIdentity)
+	}
+	leader, ok := pods[leaderPodName(*lease.Spec.HolderIdentity)]
+	if !ok {
+		return fmt.Errorf("leader election lease %s/%s is held by %s, which is not a ready controller-manager pod", ns, lease.Name, *lease.Spec.HolderIdentity)
+	}
+
+	localPort, stop, err := portForwardPod(leader, TestContext.ControllerManagerHealthPort)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", localPort))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/healthz of controller-manager pod %s/%s returns %s", ns, leader.Name, resp.Status)
+	}
+	return nil
+}
+
+// crashLoopRestartThreshold is the number of restarts after which a controller-manager container failing on its
+// last termination is considered crash-looping.
+const crashLoopRestartThreshold = 3
+
+// crashLogTailLines is how many lines of the log of the crashed controller-manager container are reported.
+var crashLogTailLines = int64(20)
+
+// AssertControllerNotCrashLooping fails if any controller-manager container is in CrashLoopBackOff or restarted at
+// least crashLoopRestartThreshold times with a failed last termination, reporting the termination reason, message
+// and the tail of the log of the crashed container. It is meant to fail the suite fast in BeforeSuite.
+func (f *Framework) AssertControllerNotCrashLooping() error {
+	ns := TestContext.ControllerManagerNamespace
+	podList, err := f.ClientSet.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: TestContext.ControllerManagerSelector,
+	})
+	if err != nil {
+		return err
+	}
+
+	var crashes []string
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			waitingReason := ""
+			if status.State.Waiting != nil {
+				waitingReason = status.State.Waiting.Reason
+			}
+			last := status.LastTerminationState.Terminated
+			failed := last != nil && last.ExitCode != 0
+			if waitingReason != "CrashLoopBackOff" && !(failed && status.RestartCount >= crashLoopRestartThreshold) {
+				continue
+			}
+
+			crash := fmt.Sprintf("container %s of pod %s/%s restarted %d times", status.Name, ns, pod.Name, status.RestartCount)
+			if last != nil {
+				crash += fmt.Sprintf(", last terminated with %s (exit code %d): %s", last.Reason, last.ExitCode, strings.TrimSpace(last.Message))
+			}
+			logs, err := f.ClientSet.CoreV1().Pods(ns).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container: status.Name,
+				Previous:  true,
+				TailLines: &crashLogTailLines,
+			}).Do(context.TODO()).Raw()
+			if err != nil {
+				crash += fmt.Sprintf("\n<fail to get logs: %v>", err)
+			} else {
+				crash += "\n" + strings.TrimSpace(string(logs))
+			}
+			crashes = append(crashes, crash)
+		}
+	}
+	if len(crashes) > 0 {
+		return fmt.Errorf("controller-manager is crash-looping:\n%s", strings.Join(crashes, "\n"))
+	}
+	return nil
+}
+
+// WaitForControllerHealthy waits until the operating controllers are reconciling, that is all the controller-manager
+// pods are ready, the leader election is held by one of them, and the leader passes its /healthz probe.
+func (f *Framework) WaitForControllerHealthy(timeout time.Duration) error {
+	Logf("Waiting up to %v for the controller-manager in namespace %s to be healthy", timeout, TestContext.ControllerManagerNamespace)
+	var lastErr error
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if lastErr = f.checkControllerHealthy(); lastErr != nil {
+			Logf("Controller-manager is not healthy yet: %v", lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for the controller-manager to be healthy: %v", timeout, lastErr)
+	}
+	return err
+}
+
+// leaseSampleInterval is how often the leader election lease is sampled while the controller-manager restarts.
+const leaseSampleInterval = 500 * time.Millisecond
+
+// leaseHolder is the holder of the leader election lease observed at a sample.
+type leaseHolder struct {
+	identity      string
+	acquireTime   time.Time
+	renewTime     time.Time
+	leaseDuration time.Duration
+}
+
+// isControllerManagerPodAlive indicates whether the controller-manager pod named by the holder identity still exists
+// and is not being deleted.
+func (f *Framework) isControllerManagerPodAlive(identity string) (bool, error) {
+	pod, err := f.ClientSet.CoreV1().Pods(TestContext.ControllerManagerNamespace).Get(context.TODO(), leaderPodName(identity), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning, nil
+}
+
+// AssertSingleLeaderDuringRestart samples the leader election lease throughout the duration while the test restarts
+// the controller-manager, and verifies the leadership is never taken over before the lease of the previous holder
+// expires while its pod is still running, which would let two leaders reconcile at once. Every handover is logged.
+func (f *Framework) AssertSingleLeaderDuringRestart(duration time.Duration) error {
+	ns, name := TestContext.ControllerManagerNamespace, TestContext.ControllerManagerLeaderElectionID
+	Logf("Monitoring leader election lease %s/%s for %v", ns, name, duration)
+
+	var prev *leaseHolder
+	var handovers int
+	err := wait.PollImmediate(leaseSampleInterval, duration, func() (bool, error) {
+		lease, err := f.ClientSet.CoordinationV1().Leases(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			// released by the previous holder
+			prev = nil
+			return false, nil
+		}
+		cur := &leaseHolder{
+			identity:      *lease.Spec.HolderIdentity,
+			renewTime:     lease.Spec.RenewTime.Time,
+			leaseDuration: time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second,
+		}
+		if lease.Spec.AcquireTime != nil {
+			cur.acquireTime = lease.Spec.AcquireTime.Time
+		}
+
+		if prev != nil && prev.identity != cur.identity {
+			handovers++
+			prevExpiry := prev.renewTime.Add(prev.leaseDuration)
+			Logf("Leadership handed over from %s (renewed at %s) to %s (acquired at %s)",
+				prev.identity, prev.renewTime.Format(time.RFC3339Nano), cur.identity, cur.acquireTime.Format(time.RFC3339Nano))
+			if cur.acquireTime.Before(prevExpiry) {
+				alive, err := f.isControllerManagerPodAlive(prev.identity)
+				if err != nil {
+					return false, err
+				}
+				if alive {
+					return false, fmt.Errorf("split brain observed at %s: %s acquired leader election lease %s/%s at %s, before the lease of %s expires at %s while its pod is still running",
+						time.Now().Format(time.RFC3339Nano), cur.identity, ns, name, cur.acquireTime.Format(time.RFC3339Nano),
+						prev.identity, prevExpiry.Format(time.RFC3339Nano))
+				}
+			}
+		}
+		prev = cur
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		Logf("Leader election lease %s/%s is handed over %d times without split brain", ns, name, handovers)
+		return nil
+	}
+	return err
+}
+
+// featureGatesFlag is the flag by which the controller-manager takes its feature gates.
+const featureGatesFlag = "--feature-gates="
+
+// setFeatureGateArg returns a copy of the args with the gate set in the feature gates flag.
+func setFeatureGateArg(args []string, gate string, enabled bool) []string {
+	newArgs := make([]string, 0, len(args)+1)
+	var gates []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, featureGatesFlag) {
+			newArgs = append(newArgs, arg)
+			continue
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(arg, featureGatesFlag), ",") {
+			if kv != "" && strings.TrimSpace(strings.SplitN(kv, "=", 2)[0]) != gate {
+				gates = append(gates, kv)
+			}
+		}
+	}
+	gates = append(gates, fmt.Sprintf("%s=%t", gate, enabled))
+	return append(newArgs, featureGatesFlag+strings.Join(gates, ","))
+}
+
+// getControllerManagerWorkload returns the workload running the controller-manager, i.e. the Deployment installed by
+// the kustomize manifests or the StatefulSet installed by the chart, which is found through the controller
+// ownerReference of the leader pod, or of any running pod if the leader cannot be found.
+func (f *Framework) getControllerManagerWorkload() (client.Object, error) {
+	pod, err := f.getControllerManagerPod()
+	if err != nil {
+		return nil, err
+	}
+	ref := metav1.GetControllerOf(pod)
+	if ref != nil && ref.Kind == "ReplicaSet" {
+		rs, err := f.ClientSet.AppsV1().ReplicaSets(pod.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ref = metav1.GetControllerOf(rs)
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("controller-manager pod %s/%s is not controlled by a workload", pod.Namespace, pod.Name)
+	}
+
+	switch ref.Kind {
+	case "Deployment":
+		return f.ClientSet.AppsV1().Deployments(pod.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "StatefulSet":
+		return f.ClientSet.AppsV1().StatefulSets(pod.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("controller-manager pod %s/%s is controlled by unsupported %s %s", pod.Namespace, pod.Name, ref.Kind, ref.Name)
+	}
+}
+
+// managerContainer returns the manager container of the controller-manager workload, which is the default container
+// or otherwise the first one.
+func managerContainer(workload client.Object) *corev1.Container {
+	var template *corev1.PodTemplateSpec
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		template = &w.Spec.Template
+	case *appsv1.StatefulSet:
+		template = &w.Spec.Template
+	}
+	defaultContainer := template.Annotations["kubectl.kubernetes.io/default-container"]
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == defaultContainer {
+			return &template.Spec.Containers[i]
+		}
+	}
+	return &template.Spec.Containers[0]
+}
+
+// isRolledOut indicates whether all the replicas of the controller-manager workload are updated and available.
+func isRolledOut(workload client.Object) bool {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		replicas := int32(1)
+		if w.Spec.Replicas != nil {
+			replicas = *w.Spec.Replicas
+		}
+		return w.Status.ObservedGeneration >= w.Generation &&
+			w.Status.Replicas == replicas &&
+			w.Status.UpdatedReplicas == replicas &&
+			w.Status.AvailableReplicas == replicas
+	case *appsv1.StatefulSet:
+		replicas := int32(1)
+		if w.Spec.Replicas != nil {
+			replicas = *w.Spec.Replicas
+		}
+		return w.Status.ObservedGeneration >= w.Generation &&
+			w.Status.CurrentRevision == w.Status.UpdateRevision &&
+			w.Status.UpdatedReplicas == replicas &&
+			w.Status.ReadyReplicas == replicas
+	}
+	return false
+}
+
+// rolloutControllerManager sets the args of the manager container of the controller-manager, and waits until its
+// workload is rolled out and the controller-manager is healthy again.
+func (f *Framework) rolloutControllerManager(setArgs func(args []string) []string, timeout time.Duration) error {
+	var workload client.Object
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		if workload, err = f.getControllerManagerWorkload(); err != nil {
+			return err
+		}
+		container := managerContainer(workload)
+		container.Args = setArgs(container.Args)
+		return f.Client.Update(context.TODO(), workload)
+	})
+	if err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for controller-manager %s/%s to be rolled out", timeout, workload.GetNamespace(), workload.GetName())
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if err := f.refreshObject(workload); err != nil {
+			return false, err
+		}
+		return isRolledOut(workload), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", workload)
+		return fmt.Errorf("gave up after waiting %v for controller-manager %s/%s to be rolled out", timeout, workload.GetNamespace(), workload.GetName())
+	}
+	if err != nil {
+		return err
+	}
+	return f.WaitForControllerHealthy(timeout)
+}
+
+// WithControllerFeatureGate sets the feature gate of the controller-manager, waits until its new pods are ready and
+// one of them leads, runs fn, and then restores the original args of the controller-manager. A failure to restore
+// is only logged if fn has already failed, so that the original failure is reported.
+func (f *Framework) WithControllerFeatureGate(gate string, enabled bool, fn func()) {
+	workload, err := f.getControllerManagerWorkload()
+	ExpectNoError(err)
+	originalArgs := managerContainer(workload).Args
+	timeout := TestContext.SystemPodsStartupTimeout
+
+	Logf("Setting feature gate %s=%t of the controller-manager", gate, enabled)
+	defer func() {
+		failure := recover()
+		Logf("Restoring feature gates of the controller-manager")
+		err := f.rolloutControllerManager(func([]string) []string { return originalArgs }, timeout)
+		if failure != nil {
+			if err != nil {
+				Logf("Failed to restore feature gates of the controller-manager: %v", err)
+			}
+			panic(failure)
+		}
+		ExpectNoError(err)
+	}()
+	ExpectNoError(f.rolloutControllerManager(func(args []string) []string {
+		return setFeatureGateArg(args, gate, enabled)
+	}, timeout))
+
+	fn()
+}
+
+// GrepControllerLogs returns the lines logged by the manager container of the controller-manager, preferring the
+// leader, since the given time which match the regular expression pattern, e.g. the namespace/name of an object.
+func (f *Framework) GrepControllerLogs(pattern string, since time.Time) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := f.getControllerManagerPod()
+	if err != nil {
+		return nil, err
+	}
+	container := pod.Annotations["kubectl.kubernetes.io/default-container"]
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	sinceTime := metav1.NewTime(since)
+	stream, err := f.ClientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		SinceTime: &sinceTime,
+	}).Stream(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if re.MatchString(scanner.Text()) {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+)
+
+// operatingGroupSuffix is the suffix of all the API groups served by operating.
+const operatingGroupSuffix = "kusionstack.io"
+
+// crdResource is the resource of CustomResourceDefinitions, which are read via the dynamic client.
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// DiscoverOperatingGVK queries discovery for the preferred version of the given kind within the
+// *.kusionstack.io API groups, so that tests do not depend on a hardcoded API version.
+func (f *Framework) DiscoverOperatingGVK(kind string) (schema.GroupVersionKind, error) {
+	resourceLists, err := f.ClientSet.Discovery().ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return schema.GroupVersionKind{}, err
+	}
+
+	var found []schema.GroupVersionKind
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return schema.GroupVersionKind{}, err
+		}
+		if gv.Group != operatingGroupSuffix && !strings.HasSuffix(gv.Group, "."+operatingGroupSuffix) {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			// skip subresources, which are reported with the kind of their parent
+			if resource.Kind == kind && !strings.Contains(resource.Name, "/") {
+				found = append(found, gv.WithKind(kind))
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return schema.GroupVersionKind{}, fmt.Errorf("kind %s is not served in any %s group", kind, operatingGroupSuffix)
+	case 1:
+		return found[0], nil
+	default:
+		return schema.GroupVersionKind{}, fmt.Errorf("kind %s is served in multiple %s groups: %v", kind, operatingGroupSuffix, found)
+	}
+}
+
+// getCRDVersions returns the served versions of the CustomResourceDefinition, e.g. collasets.apps.kusionstack.io,
+// together with its storage version.
+func (f *Framework) getCRDVersions(crdName string) (served []string, storage string, err error) {
+	crd, err := f.DynamicClient.Resource(crdResource).Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return nil, "", err
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(version, "name")
+		if isServed, _, _ := unstructured.NestedBool(version, "served"); isServed {
+			served = append(served, name)
+		}
+		if isStorage, _, _ := unstructured.NestedBool(version, "storage"); isStorage {
+			storage = name
+		}
+	}
+	return served, storage, nil
+}
+
+// GetCRDServedVersions returns the versions served by the CustomResourceDefinition, so that tests can skip or
+// branch on whether an API version is still served.
+func (f *Framework) GetCRDServedVersions(crdName string) ([]string, error) {
+	served, storage, err := f.getCRDVersions(crdName)
+	if err != nil {
+		return nil, err
+	}
+	Logf("CustomResourceDefinition %s serves versions %v, storage version %s", crdName, served, storage)
+	return served, nil
+}
+
+// GetCRDStorageVersion returns the version in which the CustomResourceDefinition is persisted.
+func (f *Framework) GetCRDStorageVersion(crdName string) (string, error) {
+	_, storage, err := f.getCRDVersions(crdName)
+	if err != nil {
+		return "", err
+	}
+	if storage == "" {
+		return "", fmt.Errorf("CustomResourceDefinition %s has no storage version", crdName)
+	}
+	return storage, nil
+}
+
+// tableAcceptHeader requests the server-side rendering of objects as a Table, as kubectl get does.
+const tableAcceptHeader = "application/json;as=Table;v=v1;g=meta.k8s.io,application/json"
+
+// getTableCell fetches the object in the framework namespace rendered as a Table, and returns the cell of the column.
+func (f *Framework) getTableCell(gvr schema.GroupVersionResource, name, columnName string) (string, error) {
+	prefix := "/apis/" + gvr.Group
+	if gvr.Group == "" {
+		prefix = "/api"
+	}
+	absPath := fmt.Sprintf("%s/%s/namespaces/%s/%s/%s", prefix, gvr.Version, f.Namespace.Name, gvr.Resource, name)
+	data, err := f.ClientSet.Discovery().RESTClient().Get().AbsPath(absPath).SetHeader("Accept", tableAcceptHeader).Do(context.TODO()).Raw()
+	if err != nil {
+		return "", err
+	}
+	table := &metav1.Table{}
+	if err := json.Unmarshal(data, table); err != nil {
+		return "", fmt.Errorf("fail to decode table of %s %s: %v", gvr.String(), name, err)
+	}
+
+	column := -1
+	var columns []string
+	for i, def := range table.ColumnDefinitions {
+		columns = append(columns, def.Name)
+		if strings.EqualFold(def.Name, columnName) {
+			column = i
+		}
+	}
+	if column < 0 {
+		return "", fmt.Errorf("%s has no printer column %s, columns %v", gvr.String(), columnName, columns)
+	}
+	if len(table.Rows) != 1 || len(table.Rows[0].Cells) <= column {
+		return "", fmt.Errorf("unexpected table of %s %s with %d rows", gvr.String(), name, len(table.Rows))
+	}
+	cell := table.Rows[0].Cells[column]
+	if cell == nil {
+		return "", nil
+	}
+	return fmt.Sprint(cell), nil
+}
+
+// AssertPrinterColumn waits until the printer column of the object in the framework namespace, as rendered by
+// kubectl get from the additionalPrinterColumns of its CustomResourceDefinition, shows the expected value.
+func (f *Framework) AssertPrinterColumn(gvr schema.GroupVersionResource, name, columnName, expected string, timeout time.Duration) error {
+	var actual string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if actual, err = f.getTableCell(gvr, name, columnName); err != nil {
+			return false, err
+		}
+		return actual == expected, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for column %s of %s %s/%s to be %q, got %q",
+			timeout, columnName, gvr.String(), f.Namespace.Name, name, expected, actual)
+	}
+	return err
+}
+
+// AssertStatusSubresourceEnabled verifies the CustomResourceDefinition of the resource enables the status subresource
+// for the version of gvr, so that a misconfigured CRD is reported precisely rather than by failing status updates.
+func (f *Framework) AssertStatusSubresourceEnabled(gvr schema.GroupVersionResource) error {
+	crdName := gvr.GroupResource().String()
+	crd, err := f.DynamicClient.Resource(crdResource).Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(version, "name"); name != gvr.Version {
+			continue
+		}
+		if _, found, _ := unstructured.NestedMap(version, "subresources", "status"); !found {
+			return fmt.Errorf("CustomResourceDefinition %s does not enable the status subresource for version %s, "+
+				"status updates of %s are applied to the whole object", crdName, gvr.Version, gvr.Resource)
+		}
+		return nil
+	}
+	return fmt.Errorf("CustomResourceDefinition %s has no version %s", crdName, gvr.Version)
+}
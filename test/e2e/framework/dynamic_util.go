@@ -0,0 +1,46 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DynamicList lists the resources of gvr in the framework namespace via the dynamic client. The returned error
+// wraps the one of the API server, so that it can be checked by apierrors.
+func (f *Framework) DynamicList(gvr schema.GroupVersionResource, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := f.DynamicClient.Resource(gvr).Namespace(f.Namespace.Name).List(context.TODO(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("fail to list %s in namespace %s: %w", gvr.String(), f.Namespace.Name, err)
+	}
+	return list, nil
+}
+
+// DynamicGet gets the resource of gvr with the given name in the framework namespace via the dynamic client.
+// The returned error wraps the one of the API server, so that it can be checked by apierrors.
+func (f *Framework) DynamicGet(gvr schema.GroupVersionResource, name string) (*unstructured.Unstructured, error) {
+	obj, err := f.DynamicClient.Resource(gvr).Namespace(f.Namespace.Name).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get %s %s/%s: %w", gvr.String(), f.Namespace.Name, name, err)
+	}
+	return obj, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// watchEventsWithReason watches the events of the object with the given reason until ctx is done, and returns the
+// first one seen. Events already emitted are seen as well.
+func (f *Framework) watchEventsWithReason(ctx context.Context, obj client.Object, reason string) (*corev1.Event, error) {
+	w, err := f.ClientSet.CoreV1().Events(obj.GetNamespace()).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{"involvedObject.uid": string(obj.GetUID()), "reason": reason}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	event, err := watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		return event.Type == watch.Added || event.Type == watch.Modified, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event.Object.(*corev1.Event), nil
+}
+
+// AssertEventEmitted waits until an event with the given reason is emitted for the object.
+func (f *Framework) AssertEventEmitted(obj client.Object, reason string, timeout time.Duration) error {
+	Logf("Waiting up to %v for event %s of %s/%s", timeout, reason, obj.GetNamespace(), obj.GetName())
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+
+	event, err := f.watchEventsWithReason(ctx, obj, reason)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for event %s of %s/%s", timeout, reason, obj.GetNamespace(), obj.GetName())
+	}
+	if err != nil {
+		return err
+	}
+	Logf("Event %s of %s/%s emitted: %s", reason, obj.GetNamespace(), obj.GetName(), event.Message)
+	return nil
+}
+
+// AssertEventNotEmitted verifies no event with the given reason is emitted for the object throughout the window.
+func (f *Framework) AssertEventNotEmitted(obj client.Object, reason string, window time.Duration) error {
+	Logf("Verifying event %s of %s/%s is not emitted for %v", reason, obj.GetNamespace(), obj.GetName(), window)
+	ctx, cancel := context.WithTimeout(context.TODO(), window)
+	defer cancel()
+
+	event, err := f.watchEventsWithReason(ctx, obj, reason)
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected event %s of %s/%s emitted: %s", reason, obj.GetNamespace(), obj.GetName(), event.Message)
+}
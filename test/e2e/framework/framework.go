@@ -18,26 +18,40 @@ package framework
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
 	// DefaultNamespaceDeletionTimeout is timeout duration for waiting for a namespace deletion.
 	DefaultNamespaceDeletionTimeout = 5 * time.Minute
-	defaultServiceAccountName       = "default"
+	// DefaultClientTimeout is the default timeout of a single request sent by the framework clients.
+	DefaultClientTimeout      = 30 * time.Second
+	defaultServiceAccountName = "default"
 )
 
 var (
@@ -80,6 +94,8 @@ type Framework struct {
 
 	Client    client.Client
 	ClientSet clientset.Interface
+	// restConfig is the config the clients are built from.
+	restConfig *rest.Config
 
 	DynamicClient dynamic.Interface
 
@@ -102,6 +118,16 @@ type Framework struct {
 	TestSummaries []TestDataSummary
 
 	AfterEachActions []func()
+
+	// cleanups are registered by helpers during a single test, and run in reverse order by AfterEach.
+	cleanups []func()
+
+	// testStartTime is the time when BeforeEach of the current test starts.
+	testStartTime time.Time
+
+	// trackedObjects are the namespace/name of the objects under test, whose controller logs are dumped if the
+	// current test fails.
+	trackedObjects []string
 }
 
 // Options is a struct for managing test framework options.
@@ -109,6 +135,9 @@ type Options struct {
 	ClientQPS    float32
 	ClientBurst  int
 	GroupVersion *schema.GroupVersion
+	// ClientTimeout bounds a single request to the apiserver, which is distinct from the timeouts of polling
+	// for a condition. TestContext.ClientTimeout or DefaultClientTimeout is used if it is not set.
+	ClientTimeout time.Duration
 }
 
 type TestDataSummary interface {
@@ -126,6 +155,55 @@ func KusionstackDescribe(text string, body func()) bool {
 	return ginkgo.Describe("[kusionstack] "+text, body)
 }
 
+// matrixCombinations returns the cartesian product of the values of the dimensions, ordered by the sorted names
+// of the dimensions and then by the order of the values, together with the description of each combination.
+func matrixCombinations(dims map[string][]interface{}) ([]map[string]interface{}, []string) {
+	names := make([]string, 0, len(dims))
+	for name := range dims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combos := []map[string]interface{}{{}}
+	for _, name := range names {
+		var next []map[string]interface{}
+		for _, combo := range combos {
+			for _, value := range dims[name] {
+				c := make(map[string]interface{}, len(combo)+1)
+				for k, v := range combo {
+					c[k] = v
+				}
+				c[name] = value
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+
+	descs := make([]string, 0, len(combos))
+	for _, combo := range combos {
+		parts := make([]string, 0, len(names))
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("%s=%v", name, combo[name]))
+		}
+		descs = append(descs, strings.Join(parts, ", "))
+	}
+	return combos, descs
+}
+
+// Matrix registers an It for each combination in the cartesian product of the values of the dimensions, which
+// invokes fn with the combination, e.g. {"policy": "InPlaceIfPossible", "replicas": 3}. Like ginkgo.It, it must
+// be called while the spec tree is built, e.g. within KusionstackDescribe.
+func (f *Framework) Matrix(dims map[string][]interface{}, fn func(combo map[string]interface{})) {
+	combos, descs := matrixCombinations(dims)
+	for i := range combos {
+		combo := combos[i]
+		ginkgo.It(descs[i], func() {
+			fn(combo)
+		})
+	}
+}
+
 // NewDefaultFramework makes a new framework and sets up a BeforeEach/AfterEach for
 // you (you can write additional before/after each functions).
 func NewDefaultFramework(baseName string) *Framework {
@@ -150,11 +228,36 @@ func NewFramework(baseName string, options Options, client clientset.Interface)
 	return f
 }
 
+// configureClient applies the client options of the framework to the rest config.
+func (f *Framework) configureClient(config *rest.Config) {
+	config.QPS = f.Options.ClientQPS
+	config.Burst = f.Options.ClientBurst
+	if f.Options.GroupVersion != nil {
+		config.GroupVersion = f.Options.GroupVersion
+	}
+	if TestContext.KubeAPIContentType != "" {
+		config.ContentType = TestContext.KubeAPIContentType
+	}
+
+	config.Timeout = f.Options.ClientTimeout
+	if config.Timeout == 0 {
+		config.Timeout = TestContext.ClientTimeout
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultClientTimeout
+	}
+
+	if TestContext.AuditClientRequests {
+		config.Wrap(newAuditRoundTripper)
+	}
+}
+
 // BeforeEach gets a client and makes a namespace.
 func (f *Framework) BeforeEach() {
 	// The fact that we need this feels like a bug in ginkgo.
 	// https://github.com/onsi/ginkgo/issues/222
 	f.cleanupHandle = AddCleanupAction(f.AfterEach)
+	f.testStartTime = time.Now()
 	if f.ClientSet == nil {
 		ginkgo.By("Creating a kubernetes client")
 		config, err := LoadConfig()
@@ -168,14 +271,7 @@ func (f *Framework) BeforeEach() {
 				componentTexts)
 		}
 
-		config.QPS = f.Options.ClientQPS
-		config.Burst = f.Options.ClientBurst
-		if f.Options.GroupVersion != nil {
-			config.GroupVersion = f.Options.GroupVersion
-		}
-		if TestContext.KubeAPIContentType != "" {
-			config.ContentType = TestContext.KubeAPIContentType
-		}
+		f.configureClient(config)
 		f.ClientSet, err = clientset.NewForConfig(config)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 		f.DynamicClient, err = dynamic.NewForConfig(config)
@@ -185,6 +281,7 @@ func (f *Framework) BeforeEach() {
 		jsonConfig.ContentType = "application/json"
 		f.Client, err = client.New(config, client.Options{})
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		f.restConfig = config
 
 		// create scales getter, set GroupVersion and NegotiatedSerializer to default values
 		// as they are required when creating a REST client.
@@ -255,6 +352,7 @@ func (f *Framework) AfterEach() {
 		f.Namespace = nil
 		f.Client = nil
 		f.ClientSet = nil
+		f.restConfig = nil
 		f.namespacesToDelete = nil
 
 		// if we had errors deleting, report them now.
@@ -273,12 +371,24 @@ func (f *Framework) AfterEach() {
 		if !f.SkipNamespaceCreation {
 			DumpAllNamespaceInfo(f.ClientSet, f.Namespace.Name)
 		}
+		f.dumpTrackedObjectLogs()
 	}
+	f.trackedObjects = nil
 
 	for _, f := range f.AfterEachActions {
 		f()
 	}
 
+	f.profileIfSlow()
+
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+	f.cleanups = nil
+
+	printSummaries(f.TestSummaries, f.BaseName)
+	f.TestSummaries = nil
+
 	TestContext.CloudConfig.Provider.FrameworkAfterEach(f)
 
 	// Check whether all nodes are ready after the test.
@@ -289,6 +399,48 @@ func (f *Framework) AfterEach() {
 	//}
 }
 
+// summaryJSON marshals the summary of the given kind into indented JSON, or logs the error and returns "".
+func summaryJSON(kind string, v interface{}) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		Logf("Error in marshaling %s: %v", kind, err)
+		return ""
+	}
+	return string(data)
+}
+
+// printSummaries prints the summaries collected by the test, or writes them to ReportDir if it is set.
+func printSummaries(summaries []TestDataSummary, testBaseName string) {
+	now := time.Now()
+	for i := range summaries {
+		Logf("Printing summary: %v", summaries[i].SummaryKind())
+		switch TestContext.OutputPrintType {
+		case "hr":
+			if TestContext.ReportDir == "" {
+				Logf(summaries[i].PrintHumanReadable())
+			} else {
+				filePath := path.Join(TestContext.ReportDir, summaries[i].SummaryKind()+"_"+testBaseName+"_"+now.Format(time.RFC3339)+".txt")
+				if err := os.WriteFile(filePath, []byte(summaries[i].PrintHumanReadable()), 0644); err != nil {
+					Logf("Failed to write file %v with test performance data: %v", filePath, err)
+				}
+			}
+		default:
+			if TestContext.OutputPrintType != "json" {
+				Logf("Unknown output type: %v. Printing JSON", TestContext.OutputPrintType)
+			}
+			if TestContext.ReportDir == "" {
+				Logf("%v JSON\n%v", summaries[i].SummaryKind(), summaries[i].PrintJSON())
+			} else {
+				filePath := path.Join(TestContext.ReportDir, summaries[i].SummaryKind()+"_"+testBaseName+"_"+now.Format(time.RFC3339)+".json")
+				Logf("Writing to %s", filePath)
+				if err := os.WriteFile(filePath, []byte(summaries[i].PrintJSON()), 0644); err != nil {
+					Logf("Failed to write file %v with test performance data: %v", filePath, err)
+				}
+			}
+		}
+	}
+}
+
 // CreateNamespace is used to create namespace
 func (f *Framework) CreateNamespace(baseName string, labels map[string]string) (*corev1.Namespace, error) {
 	createTestingNS := TestContext.CreateTestingNS
@@ -303,6 +455,61 @@ func (f *Framework) CreateNamespace(baseName string, labels map[string]string) (
 	return ns, err
 }
 
+// CreateNamespaceAnnotated creates a namespace like CreateNamespace, labeled with e2e-framework=<BaseName> unless
+// overridden, and sets the annotations on it, for features gated on namespace annotations.
+func (f *Framework) CreateNamespaceAnnotated(baseName string, annotations, labels map[string]string) (*corev1.Namespace, error) {
+	nsLabels := map[string]string{"e2e-framework": f.BaseName}
+	for k, v := range labels {
+		nsLabels[k] = v
+	}
+	ns, err := f.CreateNamespace(baseName, nsLabels)
+	if err != nil || len(annotations) == 0 {
+		return ns, err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return ns, err
+	}
+	return f.ClientSet.CoreV1().Namespaces().Patch(context.TODO(), ns.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
+
+// FanOutCreate creates the object built by factory in each of the namespaces with at most parallelism workers,
+// returning the aggregated errors. Objects in namespaces created by the framework go away with them, while
+// the others are deleted in AfterEach.
+func (f *Framework) FanOutCreate(namespaces []string, factory func(ns string) client.Object, parallelism int) error {
+	managed := map[string]bool{}
+	for _, ns := range f.namespacesToDelete {
+		managed[ns.Name] = true
+	}
+
+	var lock sync.Mutex
+	var errs []error
+	workqueue.ParallelizeUntil(context.TODO(), parallelism, len(namespaces), func(i int) {
+		ns := namespaces[i]
+		obj := factory(ns)
+		obj.SetNamespace(ns)
+		err := f.Client.Create(context.TODO(), obj)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %v", ns, err))
+			return
+		}
+		if !managed[ns] {
+			f.addCleanup(func() {
+				if err := f.Client.Delete(context.TODO(), obj); err != nil && !apierrors.IsNotFound(err) {
+					Logf("Failed to delete %s/%s: %v", ns, obj.GetName(), err)
+				}
+			})
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
 // AddNamespacesToDelete adds one or more namespaces to be deleted when the test
 // completes.
 func (f *Framework) AddNamespacesToDelete(namespaces ...*corev1.Namespace) {
@@ -315,11 +522,122 @@ func (f *Framework) AddNamespacesToDelete(namespaces ...*corev1.Namespace) {
 	}
 }
 
+// ForceDeleteNamespace deletes the namespace, and if it is stuck terminating beyond the namespace deletion timeout,
+// strips its spec.finalizers via the finalize subresource and waits for it to be removed. Stripping finalizers is
+// destructive, so it is only done if TestContext.AllowForceDeleteNamespace is set.
+func (f *Framework) ForceDeleteNamespace(name string) error {
+	timeout := DefaultNamespaceDeletionTimeout
+	if f.NamespaceDeletionTimeout != 0 {
+		timeout = f.NamespaceDeletionTimeout
+	}
+	err := deleteNS(f.ClientSet, f.DynamicClient, name, timeout)
+	if err == nil || apierrors.IsNotFound(err) {
+		return nil
+	}
+	if !TestContext.AllowForceDeleteNamespace {
+		return fmt.Errorf("%v, set --allow-force-delete-namespace to strip its finalizers", err)
+	}
+
+	ns, getErr := f.ClientSet.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(getErr) {
+		return nil
+	} else if getErr != nil {
+		return getErr
+	}
+	Logf("WARNING: FORCE DELETING namespace %s stuck terminating, stripping its finalizers %v: %v", name, ns.Spec.Finalizers, err)
+	ns.Spec.Finalizers = nil
+	if _, err := f.ClientSet.CoreV1().Namespaces().Finalize(context.TODO(), ns, metav1.UpdateOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		_, err := f.ClientSet.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for namespace %s to be removed after stripping its finalizers", timeout, name)
+	}
+	return err
+}
+
+// TrackObject marks obj as under test, so that the controller-manager log lines mentioning it are dumped if the
+// current test fails.
+func (f *Framework) TrackObject(obj client.Object) {
+	f.trackedObjects = append(f.trackedObjects, obj.GetNamespace()+"/"+obj.GetName())
+}
+
+// dumpTrackedObjectLogs logs the controller-manager log lines since the start of the current test mentioning any
+// tracked object.
+func (f *Framework) dumpTrackedObjectLogs() {
+	for _, key := range f.trackedObjects {
+		lines, err := f.GrepControllerLogs(regexp.QuoteMeta(key), f.testStartTime)
+		if err != nil {
+			Logf("Unable to grep controller-manager logs for %s: %v", key, err)
+			continue
+		}
+		Logf("Controller-manager logs for %s (%d lines):\n%s", key, len(lines), strings.Join(lines, "\n"))
+	}
+}
+
+// addCleanup registers a function to run in AfterEach of the current test.
+func (f *Framework) addCleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+// RunBackground runs fn in a goroutine with a context which is cancelled in AfterEach, e.g. to generate churn
+// while the test asserts in the foreground. The returned wait blocks until fn returns and returns its error,
+// where an error caused by the cancellation in AfterEach is not reported.
+func (f *Framework) RunBackground(fn func(ctx context.Context) error) (wait func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer ginkgo.GinkgoRecover()
+		defer close(done)
+		err = fn(ctx)
+	}()
+
+	// stop fn before the namespace is torn down, so that it does not leak into the next test
+	f.addCleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return func() error {
+		<-done
+		if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return nil
+		}
+		return err
+	}
+}
+
 // WaitForPodRunning waits for the pod to run in the namespace.
 func (f *Framework) WaitForPodRunning(podName string) error {
 	return WaitForPodNameRunningInNamespace(f.ClientSet, podName, f.Namespace.Name)
 }
 
+// WaitForPodReady waits for the PodReady condition of the pod in the namespace to be true, which unlike
+// WaitForPodRunning requires the readiness probes to pass. The readiness of each container is logged on timeout.
+func (f *Framework) WaitForPodReady(podName string, timeout time.Duration) error {
+	var containers []string
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, "ready", timeout, func(pod *corev1.Pod) (bool, error) {
+		containers = containers[:0]
+		for _, status := range pod.Status.ContainerStatuses {
+			containers = append(containers, fmt.Sprintf("%s=%t", status.Name, status.Ready))
+		}
+		cond := getPodReadyCondition(pod)
+		return cond != nil && cond.Status == corev1.ConditionTrue, nil
+	})
+	if err != nil {
+		Logf("Readiness of containers of pod %s/%s: [%s]", f.Namespace.Name, podName, strings.Join(containers, ", "))
+	}
+	return err
+}
+
 // KoribtoDescribe is a wrapper function for ginkgo describe.
 func KoribtoDescribe(text string, body func()) bool {
 	return ginkgo.Describe("[koribto.io] "+text, body)
@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestConfigureClientTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { TestContext.ClientTimeout = timeout }(TestContext.ClientTimeout)
+
+	tests := []struct {
+		name           string
+		optionTimeout  time.Duration
+		contextTimeout time.Duration
+		expected       time.Duration
+	}{
+		{name: "default", expected: DefaultClientTimeout},
+		{name: "from TestContext", contextTimeout: time.Minute, expected: time.Minute},
+		{name: "from Options", optionTimeout: 5 * time.Second, contextTimeout: time.Minute, expected: 5 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			TestContext.ClientTimeout = tc.contextTimeout
+			f := &Framework{Options: Options{ClientTimeout: tc.optionTimeout}}
+			config := &rest.Config{}
+			f.configureClient(config)
+			if config.Timeout != tc.expected {
+				t.Errorf("expected rest.Config.Timeout %v, got %v", tc.expected, config.Timeout)
+			}
+		})
+	}
+}
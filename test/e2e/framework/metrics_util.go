@@ -0,0 +1,481 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// portForwardTimeout is the timeout for kubectl port-forward to report the local port.
+	portForwardTimeout = 30 * time.Second
+
+	reconcileTimeMetric  = "controller_runtime_reconcile_time_seconds"
+	workqueueDepthMetric = "workqueue_depth"
+	reconcileTotalMetric = "controller_runtime_reconcile_total"
+
+	// collaSetControllerName is the name by which the CollaSet controller is registered and labels its metrics.
+	collaSetControllerName = "collaset-controller"
+	// throughputPodImage is the image of the CollaSets created by MeasureReconcileThroughput, which have no pods.
+	throughputPodImage = "registry.k8s.io/pause:3.9"
+)
+
+var portForwardRegexp = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+) -> \d+`)
+
+// getControllerManagerPod returns the running leader pod of the operating controller-manager, or any running pod of
+// it if the leader cannot be found.
+func (f *Framework) getControllerManagerPod() (*corev1.Pod, error) {
+	leader, err := f.GetControllerLeaderPod()
+	if err == nil && leader.Status.Phase == corev1.PodRunning {
+		return leader, nil
+	}
+	if err != nil {
+		Logf("Unable to find the controller-manager leader, falling back to any running pod: %v", err)
+	}
+
+	podList, err := f.ClientSet.CoreV1().Pods(TestContext.ControllerManagerNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: TestContext.ControllerManagerSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range podList.Items {
+		if podList.Items[i].Status.Phase == corev1.PodRunning {
+			return &podList.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no running controller-manager pod in namespace %s with selector %s", TestContext.ControllerManagerNamespace, TestContext.ControllerManagerSelector)
+}
+
+// portForwardControllerManager forwards a local port to the given port of a controller-manager pod via
+// kubectl port-forward, and returns the local port together with a function stopping the forwarding.
+func (f *Framework) portForwardControllerManager(remotePort int) (localPort int, stop func(), err error) {
+	pod, err := f.getControllerManagerPod()
+	if err != nil {
+		return 0, nil, err
+	}
+	return portForwardPod(pod, remotePort)
+}
+
+// portForwardPod forwards a local port to the given port of the pod via kubectl port-forward, and returns
+// the local port together with a function stopping the forwarding.
+func portForwardPod(pod *corev1.Pod, remotePort int) (localPort int, stop func(), err error) {
+	cmd := KubectlCmd("port-forward", "-n", pod.Namespace, "pod/"+pod.Name, fmt.Sprintf(":%d", remotePort))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, nil, err
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	portCh := make(chan int, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if match := portForwardRegexp.FindStringSubmatch(scanner.Text()); match != nil {
+				port, _ := strconv.Atoi(match[1])
+				portCh <- port
+				break
+			}
+		}
+		// keep draining the output, so that kubectl is never blocked on writing it
+		io.Copy(io.Discard, stdout)
+	}()
+
+	select {
+	case localPort = <-portCh:
+		return localPort, stop, nil
+	case <-time.After(portForwardTimeout):
+		stop()
+		return 0, nil, fmt.Errorf("gave up after waiting %v for port-forward to pod %s/%s", portForwardTimeout, pod.Namespace, pod.Name)
+	}
+}
+
+// fetchControllerManagerMetrics fetches the metrics served by the controller-manager in the text format.
+func (f *Framework) fetchControllerManagerMetrics() ([]byte, error) {
+	localPort, stop, err := f.portForwardControllerManager(TestContext.ControllerManagerMetricsPort)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", localPort))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to scrape controller-manager metrics: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// scrapeControllerManagerMetrics fetches and parses the metrics served by the controller-manager.
+func (f *Framework) scrapeControllerManagerMetrics() (map[string]*dto.MetricFamily, error) {
+	data, err := f.fetchControllerManagerMetrics()
+	if err != nil {
+		return nil, err
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(bytes.NewReader(data))
+}
+
+// histogramBucket is a cumulative bucket of a histogram.
+type histogramBucket struct {
+	upperBound float64
+	count      float64
+}
+
+// histogramQuantile estimates the quantile from the cumulative buckets sorted by upper bound, interpolating
+// linearly within a bucket like the histogram_quantile function of Prometheus.
+func histogramQuantile(q float64, buckets []histogramBucket) float64 {
+	if len(buckets) == 0 {
+		return math.NaN()
+	}
+	total := buckets[len(buckets)-1].count
+	if total == 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	var lowerBound, lowerCount float64
+	for i, b := range buckets {
+		if b.count >= rank {
+			if math.IsInf(b.upperBound, 1) {
+				// the quantile falls into the +Inf bucket, return the highest finite bound
+				if i > 0 {
+					return buckets[i-1].upperBound
+				}
+				return math.NaN()
+			}
+			if b.count == lowerCount {
+				return b.upperBound
+			}
+			return lowerBound + (b.upperBound-lowerBound)*(rank-lowerCount)/(b.count-lowerCount)
+		}
+		lowerBound, lowerCount = b.upperBound, b.count
+	}
+	return buckets[len(buckets)-1].upperBound
+}
+
+// ReconcilePerfSummary is a TestDataSummary of the reconcile durations of a controller.
+type ReconcilePerfSummary struct {
+	Controller  string  `json:"controller"`
+	SampleCount uint64  `json:"sampleCount"`
+	P50         float64 `json:"p50Seconds"`
+	P99         float64 `json:"p99Seconds"`
+}
+
+// SummaryKind returns the kind of the summary.
+func (s *ReconcilePerfSummary) SummaryKind() string {
+	return "ReconcilePerfSummary"
+}
+
+// PrintHumanReadable prints the summary in human readable form.
+func (s *ReconcilePerfSummary) PrintHumanReadable() string {
+	return fmt.Sprintf("controller %s reconciled %d times, p50 %.3fs, p99 %.3fs", s.Controller, s.SampleCount, s.P50, s.P99)
+}
+
+// PrintJSON prints the summary in JSON.
+func (s *ReconcilePerfSummary) PrintJSON() string {
+	return summaryJSON(s.SummaryKind(), s)
+}
+
+// SampleReconcileDuration scrapes the controller_runtime_reconcile_time_seconds histogram of the given controller
+// from the controller-manager, and returns its p50 and p99 in seconds. A ReconcilePerfSummary is recorded into
+// TestSummaries. Note the histogram accumulates since the controller-manager started.
+func (f *Framework) SampleReconcileDuration(controller string) (p50, p99 float64, err error) {
+	families, err := f.scrapeControllerManagerMetrics()
+	if err != nil {
+		return 0, 0, err
+	}
+	family, ok := families[reconcileTimeMetric]
+	if !ok {
+		return 0, 0, fmt.Errorf("metric %s is not served by the controller-manager", reconcileTimeMetric)
+	}
+
+	// merge the buckets of all the series of the controller
+	counts := map[float64]float64{}
+	var sampleCount uint64
+	for _, m := range family.GetMetric() {
+		if !hasLabel(m, "controller", controller) || m.GetHistogram() == nil {
+			continue
+		}
+		sampleCount += m.GetHistogram().GetSampleCount()
+		hasInfBucket := false
+		for _, b := range m.GetHistogram().GetBucket() {
+			counts[b.GetUpperBound()] += float64(b.GetCumulativeCount())
+			hasInfBucket = hasInfBucket || math.IsInf(b.GetUpperBound(), 1)
+		}
+		if !hasInfBucket {
+			counts[math.Inf(1)] += float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	if sampleCount == 0 {
+		return 0, 0, fmt.Errorf("no samples of %s for controller %s", reconcileTimeMetric, controller)
+	}
+
+	buckets := make([]histogramBucket, 0, len(counts))
+	for upperBound, count := range counts {
+		buckets = append(buckets, histogramBucket{upperBound: upperBound, count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].upperBound < buckets[j].upperBound })
+
+	p50, p99 = histogramQuantile(0.5, buckets), histogramQuantile(0.99, buckets)
+	f.TestSummaries = append(f.TestSummaries, &ReconcilePerfSummary{
+		Controller:  controller,
+		SampleCount: sampleCount,
+		P50:         p50,
+		P99:         p99,
+	})
+	return p50, p99, nil
+}
+
+// QueueDepthSample is the depth of a workqueue at a point in time.
+type QueueDepthSample struct {
+	Time  time.Time `json:"time"`
+	Depth int       `json:"depth"`
+}
+
+// QueueDepthSummary is a TestDataSummary of the time series of the workqueue depth of a controller.
+type QueueDepthSummary struct {
+	Controller string             `json:"controller"`
+	Samples    []QueueDepthSample `json:"samples"`
+}
+
+// SummaryKind returns the kind of the summary.
+func (s *QueueDepthSummary) SummaryKind() string {
+	return "QueueDepthSummary"
+}
+
+// PrintHumanReadable prints the summary in human readable form.
+func (s *QueueDepthSummary) PrintHumanReadable() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "workqueue depth of controller %s:\n", s.Controller)
+	for _, sample := range s.Samples {
+		fmt.Fprintf(buf, "%s\t%d\n", sample.Time.Format(time.RFC3339), sample.Depth)
+	}
+	return buf.String()
+}
+
+// PrintJSON prints the summary in JSON.
+func (s *QueueDepthSummary) PrintJSON() string {
+	return summaryJSON(s.SummaryKind(), s)
+}
+
+// GetWorkqueueDepth scrapes the workqueue_depth gauge of the given controller from the controller-manager. Each
+// sample is appended to the QueueDepthSummary of the controller in TestSummaries, so that a scale test records
+// how the queue drains after a burst.
+func (f *Framework) GetWorkqueueDepth(controller string) (int, error) {
+	families, err := f.scrapeControllerManagerMetrics()
+	if err != nil {
+		return 0, err
+	}
+	family, ok := families[workqueueDepthMetric]
+	if !ok {
+		return 0, fmt.Errorf("metric %s is not served by the controller-manager", workqueueDepthMetric)
+	}
+	var depth int
+	found := false
+	for _, m := range family.GetMetric() {
+		if hasLabel(m, "name", controller) && m.GetGauge() != nil {
+			depth += int(m.GetGauge().GetValue())
+			found = true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no samples of %s for controller %s", workqueueDepthMetric, controller)
+	}
+
+	sample := QueueDepthSample{Time: time.Now(), Depth: depth}
+	for _, summary := range f.TestSummaries {
+		if s, ok := summary.(*QueueDepthSummary); ok && s.Controller == controller {
+			s.Samples = append(s.Samples, sample)
+			return depth, nil
+		}
+	}
+	f.TestSummaries = append(f.TestSummaries, &QueueDepthSummary{Controller: controller, Samples: []QueueDepthSample{sample}})
+	return depth, nil
+}
+
+// sumReconcileTotal scrapes the controller_runtime_reconcile_total counter of the controller summed over all the
+// results.
+func (f *Framework) sumReconcileTotal(controller string) (float64, error) {
+	families, err := f.scrapeControllerManagerMetrics()
+	if err != nil {
+		return 0, err
+	}
+	family, ok := families[reconcileTotalMetric]
+	if !ok {
+		return 0, fmt.Errorf("metric %s is not served by the controller-manager", reconcileTotalMetric)
+	}
+	var total float64
+	for _, m := range family.GetMetric() {
+		if hasLabel(m, "controller", controller) && m.GetCounter() != nil {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total, nil
+}
+
+// ThroughputSummary is a TestDataSummary of the reconcile throughput of a controller under sustained load.
+type ThroughputSummary struct {
+	Controller       string        `json:"controller"`
+	CreateRate       int           `json:"createRate"`
+	Duration         time.Duration `json:"duration"`
+	Created          int           `json:"created"`
+	Reconciles       float64       `json:"reconciles"`
+	ReconcilesPerSec float64       `json:"reconcilesPerSec"`
+}
+
+// SummaryKind returns the kind of the summary.
+func (s *ThroughputSummary) SummaryKind() string {
+	return "ThroughputSummary"
+}
+
+// PrintHumanReadable prints the summary in human readable form.
+func (s *ThroughputSummary) PrintHumanReadable() string {
+	return fmt.Sprintf("controller %s reconciled %.0f times in %v while %d objects were created at %d/s, %.2f reconciles/s",
+		s.Controller, s.Reconciles, s.Duration, s.Created, s.CreateRate, s.ReconcilesPerSec)
+}
+
+// PrintJSON prints the summary in JSON.
+func (s *ThroughputSummary) PrintJSON() string {
+	return summaryJSON(s.SummaryKind(), s)
+}
+
+// MeasureReconcileThroughput creates CollaSets without replicas in the framework namespace at createRate per second
+// for the duration, and computes the reconciles per second of the CollaSet controller from its
+// controller_runtime_reconcile_total counter sampled before and after. A ThroughputSummary is recorded into
+// TestSummaries.
+func (f *Framework) MeasureReconcileThroughput(createRate int, duration time.Duration) (reconcilesPerSec float64, err error) {
+	if createRate <= 0 {
+		return 0, fmt.Errorf("invalid create rate %d", createRate)
+	}
+	before, err := f.sumReconcileTotal(collaSetControllerName)
+	if err != nil {
+		return 0, err
+	}
+
+	Logf("Creating CollaSets at %d/s for %v", createRate, duration)
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "pause", Image: throughputPodImage}}},
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(createRate))
+	defer ticker.Stop()
+	start := time.Now()
+	created := 0
+	for time.Since(start) < duration {
+		name := fmt.Sprintf("throughput-%d", created)
+		template.Labels = map[string]string{"app": name}
+		if err := f.Client.Create(context.TODO(), f.NewCollaSet(name, 0, template)); err != nil {
+			return 0, err
+		}
+		created++
+		<-ticker.C
+	}
+	elapsed := time.Since(start)
+
+	after, err := f.sumReconcileTotal(collaSetControllerName)
+	if err != nil {
+		return 0, err
+	}
+	reconciles := after - before
+	reconcilesPerSec = reconciles / elapsed.Seconds()
+	f.TestSummaries = append(f.TestSummaries, &ThroughputSummary{
+		Controller:       collaSetControllerName,
+		CreateRate:       createRate,
+		Duration:         elapsed,
+		Created:          created,
+		Reconciles:       reconciles,
+		ReconcilesPerSec: reconcilesPerSec,
+	})
+	return reconcilesPerSec, nil
+}
+
+// hasLabel indicates whether the metric has the label with the given value.
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue() == value
+		}
+	}
+	return false
+}
+
+// seriesKey formats the labels of the metric into a key identifying its series, e.g. {controller="x",result="y"}.
+func seriesKey(m *dto.Metric) string {
+	var pairs []string
+	for _, l := range m.GetLabel() {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// AssertCounterMonotonic scrapes the counter of the given name from the controller-manager the given number of
+// samples at the interval, and fails if any of its series ever decreases, which indicates a metrics registration
+// bug or a controller-manager restart invalidating other metric based assertions.
+func (f *Framework) AssertCounterMonotonic(metric string, samples int, interval time.Duration) error {
+	last := map[string]float64{}
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		families, err := f.scrapeControllerManagerMetrics()
+		if err != nil {
+			return err
+		}
+		family, ok := families[metric]
+		if !ok {
+			return fmt.Errorf("metric %s is not served by the controller-manager", metric)
+		}
+		if family.GetType() != dto.MetricType_COUNTER {
+			return fmt.Errorf("metric %s is a %s, not a counter", metric, family.GetType())
+		}
+		for _, m := range family.GetMetric() {
+			key, value := seriesKey(m), m.GetCounter().GetValue()
+			if prev, ok := last[key]; ok && value < prev {
+				return fmt.Errorf("counter %s%s decreased from %v to %v at sample %d of %d", metric, key, prev, value, i+1, samples)
+			}
+			last[key] = value
+		}
+	}
+	Logf("Counter %s did not decrease in %d samples of %d series", metric, samples, len(last))
+	return nil
+}
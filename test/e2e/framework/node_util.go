@@ -0,0 +1,248 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SetNodeUnschedulable cordons or uncordons the node.
+func (f *Framework) SetNodeUnschedulable(nodeName string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := f.ClientSet.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// isPodEvictable indicates whether the pod is expected to leave its node when the node is drained.
+func isPodEvictable(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, isMirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; isMirror {
+		return false
+	}
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// listEvictablePodsOnNode lists the pods on the node which are expected to leave it when it is drained.
+func (f *Framework) listEvictablePodsOnNode(nodeName string) ([]corev1.Pod, error) {
+	podList, err := f.ClientSet.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for i := range podList.Items {
+		if isPodEvictable(&podList.Items[i]) {
+			pods = append(pods, podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// DrainNode cordons the node, and evicts all the evictable pods on it respecting their PodDisruptionBudgets
+// until the node is empty. The node is uncordoned in AfterEach. On timeout, the returned error lists the pods
+// which could not be evicted together with the reason.
+func (f *Framework) DrainNode(nodeName string, timeout time.Duration) error {
+	Logf("Cordoning node %s", nodeName)
+	if err := f.SetNodeUnschedulable(nodeName, true); err != nil {
+		return err
+	}
+	f.addCleanup(func() {
+		Logf("Uncordoning node %s", nodeName)
+		if err := f.SetNodeUnschedulable(nodeName, false); err != nil {
+			Logf("Failed to uncordon node %s: %v", nodeName, err)
+		}
+	})
+
+	Logf("Waiting up to %v for node %s to be drained", timeout, nodeName)
+	blocked := map[string]error{}
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listEvictablePodsOnNode(nodeName)
+		if err != nil {
+			return false, err
+		}
+		blocked = map[string]error{}
+		for i := range pods {
+			pod := &pods[i]
+			if pod.DeletionTimestamp != nil {
+				blocked[pod.Namespace+"/"+pod.Name] = fmt.Errorf("still terminating")
+				continue
+			}
+			err := f.ClientSet.PolicyV1().Evictions(pod.Namespace).Evict(context.TODO(), &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+			})
+			if err != nil && !apierrors.IsNotFound(err) {
+				// TooManyRequests indicates the eviction is disallowed by a PodDisruptionBudget
+				blocked[pod.Namespace+"/"+pod.Name] = err
+			}
+		}
+		return len(pods) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		var msgs []string
+		for pod, evictErr := range blocked {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", pod, evictErr))
+		}
+		return fmt.Errorf("gave up after waiting %v for node %s to be drained, pods not evicted: [%s]", timeout, nodeName, strings.Join(msgs, "; "))
+	}
+	return err
+}
+
+// ForEachNode lists all the nodes and invokes fn on each of them with at most parallelism workers,
+// returning the aggregated errors.
+func (f *Framework) ForEachNode(fn func(node *corev1.Node) error, parallelism int) error {
+	nodeList, err := f.ClientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	var lock sync.Mutex
+	var errs []error
+	workqueue.ParallelizeUntil(context.TODO(), parallelism, len(nodeList.Items), func(i int) {
+		node := &nodeList.Items[i]
+		if err := fn(node); err != nil {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, fmt.Errorf("node %s: %v", node.Name, err))
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
+// nodeLossTaint is the taint which simulates the loss of a node by evicting all the pods not tolerating it.
+var nodeLossTaint = corev1.Taint{Key: "e2e.kusionstack.io/node-loss", Effect: corev1.TaintEffectNoExecute}
+
+// setNodeLossTaint adds or removes nodeLossTaint on the node.
+func (f *Framework) setNodeLossTaint(nodeName string, tainted bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := f.ClientSet.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var taints []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if !taint.MatchTaint(&nodeLossTaint) {
+				taints = append(taints, taint)
+			}
+		}
+		if tainted {
+			taint := nodeLossTaint
+			taint.TimeAdded = &metav1.Time{Time: time.Now()}
+			taints = append(taints, taint)
+		}
+		node.Spec.Taints = taints
+		_, err = f.ClientSet.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// SimulateNodeLoss simulates the loss of the node by cordoning it and tainting it with a NoExecute taint, and waits
+// until the evictable pods are gone from it. The node is restored in AfterEach.
+func (f *Framework) SimulateNodeLoss(nodeName string, timeout time.Duration) error {
+	Logf("Simulating loss of node %s", nodeName)
+	if err := f.SetNodeUnschedulable(nodeName, true); err != nil {
+		return err
+	}
+	f.addCleanup(func() {
+		Logf("Restoring node %s", nodeName)
+		if err := f.setNodeLossTaint(nodeName, false); err != nil {
+			Logf("Failed to remove taint %s from node %s: %v", nodeLossTaint.ToString(), nodeName, err)
+		}
+		if err := f.SetNodeUnschedulable(nodeName, false); err != nil {
+			Logf("Failed to uncordon node %s: %v", nodeName, err)
+		}
+	})
+	if err := f.setNodeLossTaint(nodeName, true); err != nil {
+		return err
+	}
+
+	var remaining []string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listEvictablePodsOnNode(nodeName)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for i := range pods {
+			remaining = append(remaining, pods[i].Namespace+"/"+pods[i].Name)
+		}
+		return len(remaining) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pods to leave lost node %s, remaining pods %v", timeout, nodeName, remaining)
+	}
+	return err
+}
+
+// AssertOwnedPodsRescheduled waits until the owner has all its replicas scheduled again, none of which is on offNode.
+func (f *Framework) AssertOwnedPodsRescheduled(owner client.Object, offNode string, timeout time.Duration) error {
+	if err := f.refreshObject(owner); err != nil {
+		return err
+	}
+	replicas, err := nestedInt64(owner, "spec", "replicas")
+	if err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for %d pods of %s/%s to be rescheduled off node %s", timeout, replicas, owner.GetNamespace(), owner.GetName(), offNode)
+	var placement []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		placement = nil
+		rescheduled := 0
+		for i := range pods {
+			pod := &pods[i]
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			placement = append(placement, fmt.Sprintf("%s=%s", pod.Name, pod.Spec.NodeName))
+			if pod.Spec.NodeName != "" && pod.Spec.NodeName != offNode {
+				rescheduled++
+			}
+		}
+		return rescheduled == int(replicas) && len(placement) == int(replicas), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pods of %s/%s to be rescheduled off node %s, placement %v",
+			timeout, owner.GetNamespace(), owner.GetName(), offNode, placement)
+	}
+	return err
+}
@@ -0,0 +1,473 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// serverManagedFields lists the fields stripped from an object before it is created again from a snapshot.
+var serverManagedFields = [][]string{
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "managedFields"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "selfLink"},
+	{"status"},
+}
+
+// toUnstructured converts a typed object into its unstructured content.
+func toUnstructured(obj client.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.UnstructuredContent(), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// nestedInt64 reads an integer field of the object, a missing field is treated as 0.
+func nestedInt64(obj client.Object, fields ...string) (int64, error) {
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return 0, err
+	}
+	val, _, err := unstructured.NestedInt64(content, fields...)
+	return val, err
+}
+
+// ToJSON serializes the object together with its apiVersion and kind resolved from the framework scheme,
+// so that it can be restored later by ApplyJSON.
+func (f *Framework) ToJSON(obj client.Object) ([]byte, error) {
+	gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme())
+	if err != nil {
+		return nil, err
+	}
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(content)}
+	u.SetGroupVersionKind(gvk)
+	return json.Marshal(u.Object)
+}
+
+// ApplyJSON decodes an object serialized by ToJSON via the framework scheme, strips the fields managed by
+// the server, defaults its namespace to the framework namespace if it is namespaced, and creates it.
+func (f *Framework) ApplyJSON(data []byte) (client.Object, error) {
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("fail to decode object: %s", err)
+	}
+	for _, fields := range serverManagedFields {
+		unstructured.RemoveNestedField(u.Object, fields...)
+	}
+
+	gvk := u.GroupVersionKind()
+	mapping, err := f.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && u.GetNamespace() == "" {
+		u.SetNamespace(f.Namespace.Name)
+	}
+
+	typed, err := f.Client.Scheme().New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return nil, err
+	}
+	obj, ok := typed.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a client.Object", gvk)
+	}
+
+	if err := f.Client.Create(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// evaluateJSONPath evaluates the JSONPath against the unstructured content of the object, and returns the JSON
+// encoding of the values found. found is false if the path matches nothing.
+func evaluateJSONPath(obj client.Object, jsonPath string) (value string, found bool, err error) {
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.HasPrefix(jsonPath, "{") {
+		jsonPath = "{" + jsonPath + "}"
+	}
+	parser := jsonpath.New("field").AllowMissingKeys(true)
+	if err := parser.Parse(jsonPath); err != nil {
+		return "", false, err
+	}
+	results, err := parser.FindResults(content)
+	if err != nil {
+		return "", false, err
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, v := range result {
+			values = append(values, v.Interface())
+		}
+	}
+	if len(values) == 0 {
+		return "", false, nil
+	}
+	var data []byte
+	if len(values) == 1 {
+		data, err = json.Marshal(values[0])
+	} else {
+		data, err = json.Marshal(values)
+	}
+	return string(data), true, err
+}
+
+// WaitForField waits until the value at the JSONPath of the object, e.g. {.status.replicas}, equals expected.
+// Values are compared by their JSON encoding, so expected may be of any type, e.g. int32(3) matches 3.
+func (f *Framework) WaitForField(obj client.Object, jsonPath string, expected interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return err
+	}
+	expectedValue := string(data)
+	Logf("Waiting up to %v for %s/%s to have %s equal to %s", timeout, obj.GetNamespace(), obj.GetName(), jsonPath, expectedValue)
+
+	observed := "<missing>"
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if err := f.refreshObject(obj); err != nil {
+			return false, err
+		}
+		value, found, err := evaluateJSONPath(obj, jsonPath)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			observed = "<missing>"
+			return false, nil
+		}
+		observed = value
+		return value == expectedValue, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", obj)
+		return fmt.Errorf("gave up after waiting %v for %s/%s to have %s equal to %s, last observed %s",
+			timeout, obj.GetNamespace(), obj.GetName(), jsonPath, expectedValue, observed)
+	}
+	return err
+}
+
+// summarizeObject formats the object into a single line of its kind, namespace/name, and the scalar fields and
+// condition statuses of its status.
+func (f *Framework) summarizeObject(obj client.Object) string {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if f.Client != nil {
+		if gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme()); err == nil {
+			kind = gvk.Kind
+		}
+	}
+	if kind == "" {
+		kind = reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+	}
+
+	fields := []string{fmt.Sprintf("%s %s/%s", kind, obj.GetNamespace(), obj.GetName())}
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return fields[0]
+	}
+	status, _, _ := unstructured.NestedMap(content, "status")
+	keys := make([]string, 0, len(status))
+	for k := range status {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := status[k].(type) {
+		case string, bool, int64, float64:
+			fields = append(fields, fmt.Sprintf("%s=%v", k, v))
+		case []interface{}:
+			if k != "conditions" {
+				continue
+			}
+			var conds []string
+			for _, c := range v {
+				if cond, ok := c.(map[string]interface{}); ok {
+					conds = append(conds, fmt.Sprintf("%v=%v", cond["type"], cond["status"]))
+				}
+			}
+			fields = append(fields, fmt.Sprintf("conditions=[%s]", strings.Join(conds, ",")))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// LogfObject logs the prefix followed by a grep-friendly single line summary of the object, including its kind,
+// namespace/name and key status fields.
+func (f *Framework) LogfObject(prefix string, obj client.Object) {
+	Logf("%s: %s", prefix, f.summarizeObject(obj))
+}
+
+// AssertResourceVersionStableWithin verifies the object is updated at most maxUpdates times throughout the window,
+// to detect hot-looping reconcilers which keep rewriting it. Every update is counted by watching the object from
+// its latest resourceVersion.
+func (f *Framework) AssertResourceVersionStableWithin(obj client.Object, maxUpdates int, window time.Duration) error {
+	if err := f.refreshObject(obj); err != nil {
+		return err
+	}
+	gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme())
+	if err != nil {
+		return err
+	}
+	mapping, err := f.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+
+	Logf("Verifying %s %s/%s is updated at most %d times for %v", gvk.Kind, obj.GetNamespace(), obj.GetName(), maxUpdates, window)
+	ctx, cancel := context.WithTimeout(context.TODO(), window)
+	defer cancel()
+	w, err := f.DynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace()).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", obj.GetName()).String(),
+		ResourceVersion: obj.GetResourceVersion(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var resourceVersions []string
+	_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		if event.Type != watch.Modified {
+			return false, nil
+		}
+		u, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			return false, nil
+		}
+		resourceVersions = append(resourceVersions, u.GetResourceVersion())
+		if len(resourceVersions) > maxUpdates {
+			return false, fmt.Errorf("%s %s/%s is updated %d times within %v, more than %d, resourceVersions %v",
+				gvk.Kind, obj.GetNamespace(), obj.GetName(), len(resourceVersions), window, maxUpdates, resourceVersions)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		Logf("%s %s/%s is updated %d times within %v", gvk.Kind, obj.GetNamespace(), obj.GetName(), len(resourceVersions), window)
+		return nil
+	}
+	return err
+}
+
+// SizeSummary is a TestDataSummary of the serialized size of a stored object.
+type SizeSummary struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Bytes     int    `json:"bytes"`
+}
+
+// SummaryKind returns the kind of the summary.
+func (s *SizeSummary) SummaryKind() string {
+	return "SizeSummary"
+}
+
+// PrintHumanReadable prints the summary in human readable form.
+func (s *SizeSummary) PrintHumanReadable() string {
+	return fmt.Sprintf("%s %s/%s is stored in %d bytes", s.Kind, s.Namespace, s.Name, s.Bytes)
+}
+
+// PrintJSON prints the summary in JSON.
+func (s *SizeSummary) PrintJSON() string {
+	return summaryJSON(s.SummaryKind(), s)
+}
+
+// ObjectSizeBytes fetches the object from the API server and returns the size of its JSON serialization, which
+// approximates its size in etcd, so that tests can catch status bloat. A SizeSummary is recorded into TestSummaries.
+func (f *Framework) ObjectSizeBytes(obj client.Object) (int, error) {
+	gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme())
+	if err != nil {
+		return 0, err
+	}
+	mapping, err := f.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return 0, err
+	}
+	u, err := f.DynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace()).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+
+	f.TestSummaries = append(f.TestSummaries, &SizeSummary{
+		Kind:      gvk.Kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Bytes:     len(data),
+	})
+	return len(data), nil
+}
+
+// reconcilePokeAnnotation is the annotation written to an object to trigger a reconcile of it.
+const reconcilePokeAnnotation = "e2e.kusionstack.io/reconcile-poke"
+
+// pokeReconcile triggers a reconcile of the object by writing reconcilePokeAnnotation on it, and updates obj with
+// the result, so that its resourceVersion is the one written by the poke.
+func (f *Framework) pokeReconcile(obj client.Object) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, reconcilePokeAnnotation, time.Now().Format(time.RFC3339Nano))
+	return f.Client.Patch(context.TODO(), obj, client.RawPatch(types.MergePatchType, []byte(patch)))
+}
+
+// AssertNoWriteOnNoopReconcile triggers a reconcile of an already settled object by an annotation poke, and verifies
+// the controller does not write the object throughout the timeout, i.e. its resourceVersion stays at the one of the
+// poke. The fields changed by an unexpected write are reported.
+func (f *Framework) AssertNoWriteOnNoopReconcile(obj client.Object, timeout time.Duration) error {
+	gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme())
+	if err != nil {
+		return err
+	}
+	mapping, err := f.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+	if err := f.pokeReconcile(obj); err != nil {
+		return err
+	}
+	poked, err := toUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	Logf("Verifying %s %s/%s is not written by the reconcile triggered at resourceVersion %s for %v",
+		gvk.Kind, obj.GetNamespace(), obj.GetName(), obj.GetResourceVersion(), timeout)
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+	w, err := f.DynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace()).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", obj.GetName()).String(),
+		ResourceVersion: obj.GetResourceVersion(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		u, ok := event.Object.(*unstructured.Unstructured)
+		if !ok || event.Type != watch.Modified {
+			return false, nil
+		}
+		var changed []string
+		for _, field := range []string{"spec", "status"} {
+			if !reflect.DeepEqual(poked[field], u.Object[field]) {
+				changed = append(changed, field)
+			}
+		}
+		if len(changed) == 0 {
+			changed = append(changed, "metadata")
+		}
+		return false, fmt.Errorf("%s %s/%s is written by a no-op reconcile at resourceVersion %s, changed %v",
+			gvk.Kind, obj.GetNamespace(), obj.GetName(), u.GetResourceVersion(), changed)
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// GetCondition refreshes the object and returns its condition of condType read from .status.conditions, giving
+// access to its reason, message and lastTransitionTime. A NotFound error is returned if the condition is absent.
+func (f *Framework) GetCondition(obj client.Object, condType string) (*metav1.Condition, error) {
+	if err := f.refreshObject(obj); err != nil {
+		return nil, err
+	}
+	content, err := toUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	conditions, _, err := unstructured.NestedSlice(content, "status", "conditions")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != condType {
+			continue
+		}
+		result := &metav1.Condition{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(cond, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "conditions"}, condType)
+}
+
+// CreateGenerated creates obj with its name as the generateName prefix, followed by a dash unless it already ends
+// with one, so that bursts of similar objects do not collide. Its namespace defaults to the framework namespace if it
+// is namespaced. The name assigned by the server is returned, and obj is updated with the created object.
+func (f *Framework) CreateGenerated(obj client.Object) (string, error) {
+	gvk, err := apiutil.GVKForObject(obj, f.Client.Scheme())
+	if err != nil {
+		return "", err
+	}
+	mapping, err := f.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return "", err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() == "" {
+		obj.SetNamespace(f.Namespace.Name)
+	}
+
+	prefix := obj.GetName()
+	if prefix == "" {
+		prefix = obj.GetGenerateName()
+	}
+	if !strings.HasSuffix(prefix, "-") {
+		prefix += "-"
+	}
+	obj.SetGenerateName(prefix)
+	obj.SetName("")
+
+	if err := f.Client.Create(context.TODO(), obj); err != nil {
+		return "", err
+	}
+	return obj.GetName(), nil
+}
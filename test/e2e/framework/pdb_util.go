@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// isOwnedBy indicates whether the object has an ownerReference pointing at owner.
+func isOwnedBy(obj metav1.Object, owner metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForManagedPDB waits until a PodDisruptionBudget owned by owner exists, and its minAvailable
+// equals expectedMinAvailable. The status of the PodDisruptionBudget is dumped on timeout.
+func (f *Framework) WaitForManagedPDB(owner client.Object, expectedMinAvailable intstr.IntOrString, timeout time.Duration) error {
+	Logf("Waiting up to %v for PodDisruptionBudget of %s/%s with minAvailable %s", timeout, owner.GetNamespace(), owner.GetName(), expectedMinAvailable.String())
+
+	var pdb *policyv1.PodDisruptionBudget
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		if err := f.refreshObject(owner); err != nil {
+			return false, err
+		}
+		pdbList, err := f.ClientSet.PolicyV1().PodDisruptionBudgets(owner.GetNamespace()).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		pdb = nil
+		for i := range pdbList.Items {
+			if isOwnedBy(&pdbList.Items[i], owner) {
+				pdb = &pdbList.Items[i]
+				break
+			}
+		}
+		if pdb == nil {
+			return false, nil
+		}
+		if pdb.Spec.MaxUnavailable != nil {
+			return false, fmt.Errorf("PodDisruptionBudget %s/%s sets maxUnavailable %s, expected minAvailable %s",
+				pdb.Namespace, pdb.Name, pdb.Spec.MaxUnavailable.String(), expectedMinAvailable.String())
+		}
+		return pdb.Spec.MinAvailable != nil && *pdb.Spec.MinAvailable == expectedMinAvailable, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		if pdb == nil {
+			return fmt.Errorf("gave up after waiting %v for PodDisruptionBudget owned by %s/%s to be created", timeout, owner.GetNamespace(), owner.GetName())
+		}
+		f.LogfObject("Last observed", pdb)
+		return fmt.Errorf("gave up after waiting %v for PodDisruptionBudget %s/%s to have minAvailable %s, got %v",
+			timeout, pdb.Namespace, pdb.Name, expectedMinAvailable.String(), pdb.Spec.MinAvailable)
+	}
+	return err
+}
+
+// pdbSampleInterval is how often the status of a PodDisruptionBudget is sampled while an update is monitored,
+// which is shorter than Poll to catch transient violations.
+const pdbSampleInterval = 250 * time.Millisecond
+
+// AssertUpdateRespectsPDB monitors the PodDisruptionBudget throughout the timeout while the owner is rolling
+// out an update, and fails at the first sample where currentHealthy falls below desiredHealthy, reporting the
+// time of the violation and the counts.
+func (f *Framework) AssertUpdateRespectsPDB(owner client.Object, pdbName string, timeout time.Duration) error {
+	Logf("Monitoring PodDisruptionBudget %s/%s for %v while %s/%s is updated", owner.GetNamespace(), pdbName, timeout, owner.GetNamespace(), owner.GetName())
+	var samples int
+	err := wait.PollImmediate(pdbSampleInterval, timeout, func() (bool, error) {
+		pdb, err := f.ClientSet.PolicyV1().PodDisruptionBudgets(owner.GetNamespace()).Get(context.TODO(), pdbName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		samples++
+		if pdb.Status.CurrentHealthy < pdb.Status.DesiredHealthy {
+			f.LogfObject("Violated", pdb)
+			return false, fmt.Errorf("PodDisruptionBudget %s/%s is violated during update of %s/%s at %s: currentHealthy %d < desiredHealthy %d, expectedPods %d",
+				pdb.Namespace, pdb.Name, owner.GetNamespace(), owner.GetName(), time.Now().Format(time.RFC3339Nano),
+				pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy, pdb.Status.ExpectedPods)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		Logf("PodDisruptionBudget %s/%s is respected in %d samples", owner.GetNamespace(), pdbName, samples)
+		return nil
+	}
+	return err
+}
+
+// WaitForDisruptionAllowed waits until the PodDisruptionBudget allows at least one disruption, so that eviction
+// tests, e.g. with DrainNode, wait for the budget rather than retrying evictions blindly.
+func (f *Framework) WaitForDisruptionAllowed(pdbName string, timeout time.Duration) error {
+	Logf("Waiting up to %v for PodDisruptionBudget %s/%s to allow disruptions", timeout, f.Namespace.Name, pdbName)
+	var pdb *policyv1.PodDisruptionBudget
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if pdb, err = f.ClientSet.PolicyV1().PodDisruptionBudgets(f.Namespace.Name).Get(context.TODO(), pdbName, metav1.GetOptions{}); err != nil {
+			return false, err
+		}
+		return pdb.Status.DisruptionsAllowed > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for PodDisruptionBudget %s/%s to allow disruptions: disruptionsAllowed %d, currentHealthy %d, desiredHealthy %d",
+			timeout, f.Namespace.Name, pdbName, pdb.Status.DisruptionsAllowed, pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy)
+	}
+	return err
+}
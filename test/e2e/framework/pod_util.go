@@ -0,0 +1,819 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1alpha1 "kusionstack.io/operating/apis/apps/v1alpha1"
+)
+
+// getPodReadyCondition returns the PodReady condition of the pod, or nil if it is not reported yet.
+func getPodReadyCondition(pod *corev1.Pod) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == corev1.PodReady {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// isPodReadyFor indicates whether the pod has been ready for at least the given duration at time now.
+func isPodReadyFor(pod *corev1.Pod, duration time.Duration, now time.Time) bool {
+	cond := getPodReadyCondition(pod)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return false
+	}
+	return !cond.LastTransitionTime.Add(duration).After(now)
+}
+
+// refreshObject reads the latest state of obj from the apiserver into obj itself.
+func (f *Framework) refreshObject(obj client.Object) error {
+	return f.Client.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj)
+}
+
+// listOwnedPods refreshes the owner and lists the pods in its namespace it controls.
+func (f *Framework) listOwnedPods(owner client.Object) ([]corev1.Pod, error) {
+	if err := f.refreshObject(owner); err != nil {
+		return nil, err
+	}
+	podList, err := f.ClientSet.CoreV1().Pods(owner.GetNamespace()).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for i := range podList.Items {
+		if metav1.IsControlledBy(&podList.Items[i], owner) {
+			pods = append(pods, podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// WaitForReadinessGateManaged waits until the pod declares a readiness gate of conditionType and the operator
+// has set the matching condition True in the pod status. A gate which is declared but never set, or set but never
+// True, is reported as a failure together with the last status, since it indicates a half-wired transition rule.
+func (f *Framework) WaitForReadinessGateManaged(podName, conditionType string, timeout time.Duration) error {
+	var declared bool
+	var last *corev1.PodCondition
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, fmt.Sprintf("readiness gate %s to be True", conditionType), timeout,
+		func(pod *corev1.Pod) (bool, error) {
+			declared, last = false, nil
+			for _, gate := range pod.Spec.ReadinessGates {
+				if string(gate.ConditionType) == conditionType {
+					declared = true
+					break
+				}
+			}
+			for i := range pod.Status.Conditions {
+				if string(pod.Status.Conditions[i].Type) == conditionType {
+					last = pod.Status.Conditions[i].DeepCopy()
+					break
+				}
+			}
+			return declared && last != nil && last.Status == corev1.ConditionTrue, nil
+		})
+	if err == nil || apierrors.IsNotFound(err) {
+		return err
+	}
+	if !declared {
+		return fmt.Errorf("pod %q does not declare readiness gate %s: %v", podName, conditionType, err)
+	}
+	if last == nil {
+		return fmt.Errorf("pod %q declares readiness gate %s, but the condition is never set: %v", podName, conditionType, err)
+	}
+	return fmt.Errorf("readiness gate %s of pod %q is set to %s but never True, reason: %q, message: %q: %v",
+		conditionType, podName, last.Status, last.Reason, last.Message, err)
+}
+
+// podPhaseOrder ranks the pod phases, a pod is never expected to move to a phase of lower rank.
+var podPhaseOrder = map[corev1.PodPhase]int{
+	corev1.PodPending:   0,
+	corev1.PodRunning:   1,
+	corev1.PodSucceeded: 2,
+	corev1.PodFailed:    2,
+}
+
+// AssertPodPhaseSequence watches the pod and verifies the phases it goes through, with consecutive duplicates
+// collapsed, are exactly the expected ones in order. It fails early on a skipped phase or a regression such as
+// Running -> Pending.
+func (f *Framework) AssertPodPhaseSequence(podName string, expected []corev1.PodPhase, timeout time.Duration) error {
+	Logf("Watching up to %v for pod %s/%s to go through phases %v", timeout, f.Namespace.Name, podName, expected)
+	if len(expected) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+	w, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", podName).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var observed []corev1.PodPhase
+	_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, fmt.Errorf("pod %s/%s was deleted after phases %v", f.Namespace.Name, podName, observed)
+		}
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok || pod.Status.Phase == "" {
+			return false, nil
+		}
+
+		phase := pod.Status.Phase
+		if len(observed) > 0 {
+			last := observed[len(observed)-1]
+			if phase == last {
+				return false, nil
+			}
+			if podPhaseOrder[phase] < podPhaseOrder[last] {
+				return false, fmt.Errorf("pod %s/%s regressed from %s to %s, observed phases %v", f.Namespace.Name, podName, last, phase, observed)
+			}
+		}
+		observed = append(observed, phase)
+		if len(observed) > len(expected) || observed[len(observed)-1] != expected[len(observed)-1] {
+			return false, fmt.Errorf("pod %s/%s went through phases %v, expected %v", f.Namespace.Name, podName, observed, expected)
+		}
+		return len(observed) == len(expected), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pod %s/%s to go through phases %v, observed %v", timeout, f.Namespace.Name, podName, expected, observed)
+	}
+	return err
+}
+
+// describePodEvents formats the events of the pod, e.g. FailedScheduling, to explain where it is scheduled.
+func (f *Framework) describePodEvents(podName string) string {
+	eventList, err := f.ClientSet.CoreV1().Events(f.Namespace.Name).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": podName}.AsSelector().String(),
+	})
+	if err != nil {
+		return fmt.Sprintf("<fail to list events: %v>", err)
+	}
+	var msgs []string
+	for _, e := range eventList.Items {
+		msgs = append(msgs, fmt.Sprintf("%s %s: %s", e.Type, e.Reason, e.Message))
+	}
+	return "[" + strings.Join(msgs, "; ") + "]"
+}
+
+// waitForPodScheduled waits until the pod is bound to a node, and returns the name of the node.
+func (f *Framework) waitForPodScheduled(podName string, timeout time.Duration) (string, error) {
+	var nodeName string
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, "scheduled", timeout, func(pod *corev1.Pod) (bool, error) {
+		nodeName = pod.Spec.NodeName
+		return nodeName != "", nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%v, events: %s", err, f.describePodEvents(podName))
+	}
+	return nodeName, nil
+}
+
+// AssertPodOnNode waits for the pod to be scheduled, and verifies it is placed on the given node.
+// The scheduling events of the pod are included on failure.
+func (f *Framework) AssertPodOnNode(podName, nodeName string, timeout time.Duration) error {
+	scheduledNode, err := f.waitForPodScheduled(podName, timeout)
+	if err != nil {
+		return err
+	}
+	if scheduledNode != nodeName {
+		return fmt.Errorf("pod %s/%s is scheduled to node %s, expected %s, events: %s", f.Namespace.Name, podName, scheduledNode, nodeName, f.describePodEvents(podName))
+	}
+	return nil
+}
+
+// AssertPodNotOnNode waits for the pod to be scheduled, and verifies it is not placed on the given node.
+// The scheduling events of the pod are included on failure.
+func (f *Framework) AssertPodNotOnNode(podName, nodeName string, timeout time.Duration) error {
+	scheduledNode, err := f.waitForPodScheduled(podName, timeout)
+	if err != nil {
+		return err
+	}
+	if scheduledNode == nodeName {
+		return fmt.Errorf("pod %s/%s is scheduled to node %s, which it is expected to avoid, events: %s", f.Namespace.Name, podName, nodeName, f.describePodEvents(podName))
+	}
+	return nil
+}
+
+// SnapshotPodUIDs captures the UIDs of the pods controlled by owner, as the baseline of AssertAllOwnedPodsRecreated.
+func (f *Framework) SnapshotPodUIDs(owner client.Object) (map[string]bool, error) {
+	pods, err := f.listOwnedPods(owner)
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[string]bool, len(pods))
+	for i := range pods {
+		uids[string(pods[i].UID)] = true
+	}
+	return uids, nil
+}
+
+// AssertAllOwnedPodsRecreated waits until owner controls some pods and none of them has a UID in prevUIDs,
+// which distinguishes recreations from in-place updates keeping the pods.
+func (f *Framework) AssertAllOwnedPodsRecreated(owner client.Object, prevUIDs map[string]bool, timeout time.Duration) error {
+	Logf("Waiting up to %v for all pods of %s/%s to be recreated", timeout, owner.GetNamespace(), owner.GetName())
+
+	var remaining []string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for i := range pods {
+			if prevUIDs[string(pods[i].UID)] {
+				remaining = append(remaining, pods[i].Name)
+			}
+		}
+		return len(pods) > 0 && len(remaining) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for all pods of %s/%s to be recreated, pods not recreated: %v", timeout, owner.GetNamespace(), owner.GetName(), remaining)
+	}
+	return err
+}
+
+// DeleteOwnedPodAndAssertRecreated deletes a pod controlled by owner out of band, and waits until the number of
+// pods of owner returns to its prior value, with a new pod of a UID not seen before the deletion.
+func (f *Framework) DeleteOwnedPodAndAssertRecreated(owner client.Object, podName string, timeout time.Duration) error {
+	pods, err := f.listOwnedPods(owner)
+	if err != nil {
+		return err
+	}
+	prevUIDs := map[string]bool{}
+	var prevCount int
+	var deleted *corev1.Pod
+	for i := range pods {
+		prevUIDs[string(pods[i].UID)] = true
+		if pods[i].DeletionTimestamp == nil {
+			prevCount++
+		}
+		if pods[i].Name == podName {
+			deleted = &pods[i]
+		}
+	}
+	if deleted == nil {
+		return fmt.Errorf("pod %s/%s is not controlled by %s/%s", owner.GetNamespace(), podName, owner.GetNamespace(), owner.GetName())
+	}
+
+	Logf("Deleting pod %s/%s of %s/%s", deleted.Namespace, podName, owner.GetNamespace(), owner.GetName())
+	if err := f.Client.Delete(context.TODO(), deleted); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	Logf("Waiting up to %v for %s/%s to recreate %d pods", timeout, owner.GetNamespace(), owner.GetName(), prevCount)
+	var count int
+	var created []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		count, created = 0, nil
+		for i := range pods {
+			if pods[i].DeletionTimestamp != nil {
+				continue
+			}
+			count++
+			if !prevUIDs[string(pods[i].UID)] {
+				created = append(created, pods[i].Name)
+			}
+		}
+		return count == prevCount && len(created) > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for %s/%s to recreate pod %s: %d/%d pods, new pods %v",
+			timeout, owner.GetNamespace(), owner.GetName(), podName, count, prevCount, created)
+	}
+	return err
+}
+
+// validateContainers verifies the names of the containers are non-empty and unique.
+func validateContainers(containers []corev1.Container) error {
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers specified")
+	}
+	names := map[string]bool{}
+	for i, c := range containers {
+		if c.Name == "" {
+			return fmt.Errorf("container %d has an empty name", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("container name %s is duplicated", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+// NewMultiContainerPod builds a pod in the framework namespace with the given containers, labeled with app=<name>
+// so that it can be matched by the selectors of workloads. It does not validate the containers, which is done by
+// CreateMultiContainerPod.
+func (f *Framework) NewMultiContainerPod(name string, containers []corev1.Container) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: f.Namespace.Name,
+			Name:      name,
+			Labels:    map[string]string{"app": name},
+		},
+	}
+	for i := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, *containers[i].DeepCopy())
+	}
+	return pod
+}
+
+// CreateMultiContainerPod validates the containers are named uniquely, and creates the pod built by NewMultiContainerPod.
+func (f *Framework) CreateMultiContainerPod(name string, containers []corev1.Container) (*corev1.Pod, error) {
+	if err := validateContainers(containers); err != nil {
+		return nil, fmt.Errorf("invalid containers of pod %s: %v", name, err)
+	}
+	pod := f.NewMultiContainerPod(name, containers)
+	if err := f.Client.Create(context.TODO(), pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// isImageEqual indicates whether the image reported in a container status refers to the expected image, since
+// the runtime may report it fully qualified, e.g. docker.io/library/nginx:1.25 for nginx:1.25.
+func isImageEqual(statusImage, image string) bool {
+	return statusImage == image || strings.HasSuffix(statusImage, "/"+image)
+}
+
+// WaitForOwnedPodsImage waits until the named container of every pod controlled by owner has the image in its spec.
+// If includeRunning is set, the container must also be running the image, rather than only being specified with it.
+func (f *Framework) WaitForOwnedPodsImage(owner client.Object, containerName, image string, timeout time.Duration, includeRunning bool) error {
+	Logf("Waiting up to %v for container %s of pods of %s/%s to have image %s, includeRunning %t",
+		timeout, containerName, owner.GetNamespace(), owner.GetName(), image, includeRunning)
+
+	var notUpdated []string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		notUpdated = nil
+		for i := range pods {
+			pod := &pods[i]
+			specImage := ""
+			for _, c := range pod.Spec.Containers {
+				if c.Name == containerName {
+					specImage = c.Image
+				}
+			}
+			if specImage != image {
+				notUpdated = append(notUpdated, fmt.Sprintf("%s: spec image %q", pod.Name, specImage))
+				continue
+			}
+			if !includeRunning {
+				continue
+			}
+
+			running := false
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == containerName {
+					running = status.State.Running != nil && isImageEqual(status.Image, image)
+				}
+			}
+			if !running {
+				notUpdated = append(notUpdated, fmt.Sprintf("%s: not yet running image", pod.Name))
+			}
+		}
+		return len(pods) > 0 && len(notUpdated) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for container %s of pods of %s/%s to have image %s: [%s]",
+			timeout, containerName, owner.GetNamespace(), owner.GetName(), image, strings.Join(notUpdated, "; "))
+	}
+	return err
+}
+
+// SnapshotPodLabels captures the values of the given labels of the pod, as the baseline of AssertLabelsPreserved.
+// An error is returned if the pod does not have any of the labels yet.
+func (f *Framework) SnapshotPodLabels(podName string, keys ...string) (map[string]string, error) {
+	pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, ok := pod.Labels[k]
+		if !ok {
+			return nil, fmt.Errorf("pod %s/%s has no label %s to snapshot", f.Namespace.Name, podName, k)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// AssertLabelsPreserved verifies the preserved labels of the pod, e.g. its instance-id, keep their values
+// throughout the window, while other labels are free to change. The first changed label is reported.
+func (f *Framework) AssertLabelsPreserved(podName string, preserved map[string]string, timeout time.Duration) error {
+	Logf("Verifying labels %v of pod %s/%s are preserved for %v", preserved, f.Namespace.Name, podName, timeout)
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for k, v := range preserved {
+			current, ok := pod.Labels[k]
+			if !ok {
+				return false, fmt.Errorf("label %s of pod %s/%s is removed, expected %q", k, f.Namespace.Name, podName, v)
+			}
+			if current != v {
+				return false, fmt.Errorf("label %s of pod %s/%s changed from %q to %q", k, f.Namespace.Name, podName, v, current)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// AssertPodAdopted waits until the pod has a controller ownerReference pointing at newOwner. It is meant for owners
+// which adopt orphan pods matching their selector, e.g. ReplicaSets. CollaSets are rejected, since they only list the
+// pods they already own and never adopt orphans.
+func (f *Framework) AssertPodAdopted(podName string, newOwner client.Object, timeout time.Duration) error {
+	if _, ok := newOwner.(*appsv1alpha1.CollaSet); ok {
+		return fmt.Errorf("CollaSet %s/%s never adopts orphan pods", newOwner.GetNamespace(), newOwner.GetName())
+	}
+	if err := f.refreshObject(newOwner); err != nil {
+		return err
+	}
+	var controllerRef *metav1.OwnerReference
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, "adopted by "+newOwner.GetName(), timeout, func(pod *corev1.Pod) (bool, error) {
+		controllerRef = metav1.GetControllerOf(pod)
+		return controllerRef != nil && controllerRef.UID == newOwner.GetUID(), nil
+	})
+	if err != nil && controllerRef != nil {
+		return fmt.Errorf("%v, controlled by %s %s instead", err, controllerRef.Kind, controllerRef.Name)
+	}
+	return err
+}
+
+// unavailableSampleInterval is how often the owned pods are sampled while an update is monitored, which is shorter
+// than Poll to catch transient violations.
+const unavailableSampleInterval = 500 * time.Millisecond
+
+// AssertMaxUnavailableRespected monitors the pods of the owner throughout the timeout while it is rolling out an
+// update, and fails at the first sample where the number of unavailable pods, i.e. the replicas of the owner minus
+// its ready pods, exceeds maxUnavailable scaled against the replicas. The peak is logged when the update is respected.
+func (f *Framework) AssertMaxUnavailableRespected(owner client.Object, maxUnavailable intstr.IntOrString, timeout time.Duration) error {
+	if err := f.refreshObject(owner); err != nil {
+		return err
+	}
+	replicas, err := nestedInt64(owner, "spec", "replicas")
+	if err != nil {
+		return err
+	}
+	allowed, err := intstr.GetScaledValueFromIntOrPercent(&maxUnavailable, int(replicas), false)
+	if err != nil {
+		return err
+	}
+
+	Logf("Monitoring %s/%s for %v to have at most %d of %d pods unavailable", owner.GetNamespace(), owner.GetName(), timeout, allowed, replicas)
+	var peak int
+	var peakAt time.Time
+	err = wait.PollImmediate(unavailableSampleInterval, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		var ready int
+		for i := range pods {
+			cond := getPodReadyCondition(&pods[i])
+			if pods[i].DeletionTimestamp == nil && cond != nil && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+		unavailable := int(replicas) - ready
+		if unavailable > peak {
+			peak, peakAt = unavailable, time.Now()
+		}
+		if unavailable > allowed {
+			return false, fmt.Errorf("%s/%s has %d of %d pods unavailable at %s, more than maxUnavailable %s (%d)",
+				owner.GetNamespace(), owner.GetName(), unavailable, replicas, peakAt.Format(time.RFC3339Nano), maxUnavailable.String(), allowed)
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		Logf("%s/%s peaked at %d unavailable pods at %s", owner.GetNamespace(), owner.GetName(), peak, peakAt.Format(time.RFC3339Nano))
+		return nil
+	}
+	return err
+}
+
+// podsResource is the resource of pods, whose status fields newer than the client are read via the dynamic client.
+var podsResource = corev1.SchemeGroupVersion.WithResource("pods")
+
+// podResizeSupported indicates whether the cluster resizes pods in place, as discovered by the pods/resize
+// subresource.
+func (f *Framework) podResizeSupported() (bool, error) {
+	resourceList, err := f.ClientSet.Discovery().ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range resourceList.APIResources {
+		if resource.Name == "pods/resize" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AssertContainerResourcesResized waits until the allocatedResources of the container reported in the pod status
+// match the expected ones and no resize is pending or in progress, and verifies the pod is not recreated meanwhile.
+// The test is skipped if the cluster does not resize pods in place.
+func (f *Framework) AssertContainerResourcesResized(podName, containerName string, expected corev1.ResourceList, timeout time.Duration) error {
+	supported, err := f.podResizeSupported()
+	if err != nil {
+		return err
+	}
+	if !supported {
+		ginkgo.Skip("the cluster does not support in-place pod resize")
+	}
+
+	pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	uid := pod.UID
+
+	Logf("Waiting up to %v for container %s of pod %s/%s to be resized to %v", timeout, containerName, f.Namespace.Name, podName, expected)
+	var allocated map[string]interface{}
+	var resizing []string
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		obj, err := f.DynamicClient.Resource(podsResource).Namespace(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if obj.GetUID() != uid {
+			return false, fmt.Errorf("pod %s/%s is recreated during resize, UID %s -> %s", f.Namespace.Name, podName, uid, obj.GetUID())
+		}
+
+		resizing = nil
+		if resize, _, _ := unstructured.NestedString(obj.Object, "status", "resize"); resize != "" {
+			resizing = append(resizing, "resize="+resize)
+		}
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, _ := c.(map[string]interface{})
+			condType, _, _ := unstructured.NestedString(cond, "type")
+			if condType == "PodResizePending" || condType == "PodResizeInProgress" {
+				reason, _, _ := unstructured.NestedString(cond, "reason")
+				resizing = append(resizing, condType+"="+reason)
+			}
+		}
+
+		allocated = nil
+		statuses, _, _ := unstructured.NestedSlice(obj.Object, "status", "containerStatuses")
+		for _, s := range statuses {
+			status, _ := s.(map[string]interface{})
+			if name, _, _ := unstructured.NestedString(status, "name"); name == containerName {
+				allocated, _, _ = unstructured.NestedMap(status, "allocatedResources")
+			}
+		}
+		for name, quantity := range expected {
+			value, ok := allocated[string(name)].(string)
+			if !ok {
+				return false, nil
+			}
+			actual, err := resource.ParseQuantity(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid allocated %s %q of container %s of pod %s/%s: %v", name, value, containerName, f.Namespace.Name, podName, err)
+			}
+			if actual.Cmp(quantity) != 0 {
+				return false, nil
+			}
+		}
+		return len(resizing) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for container %s of pod %s/%s to be resized to %v, allocated %v, resize status %v",
+			timeout, containerName, f.Namespace.Name, podName, expected, allocated, resizing)
+	}
+	return err
+}
+
+// AssertPodsUseImageDigest waits until the named container of every pod controlled by owner reports an imageID
+// containing the digest, e.g. sha256:<hex>. The imageID of each pod is reported on timeout.
+func (f *Framework) AssertPodsUseImageDigest(owner client.Object, containerName, digest string, timeout time.Duration) error {
+	Logf("Waiting up to %v for container %s of pods of %s/%s to run image %s", timeout, containerName, owner.GetNamespace(), owner.GetName(), digest)
+	var imageIDs []string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		replicas, err := nestedInt64(owner, "spec", "replicas")
+		if err != nil {
+			return false, err
+		}
+		imageIDs = nil
+		matched := 0
+		for i := range pods {
+			if pods[i].DeletionTimestamp != nil {
+				continue
+			}
+			imageID := "<none>"
+			if status := containerStatusOf(&pods[i], containerName); status != nil && status.ImageID != "" {
+				imageID = status.ImageID
+			}
+			if strings.Contains(imageID, digest) {
+				matched++
+			}
+			imageIDs = append(imageIDs, fmt.Sprintf("%s=%s", pods[i].Name, imageID))
+		}
+		return matched == len(imageIDs) && matched == int(replicas), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for container %s of pods of %s/%s to run image %s, imageIDs %v",
+			timeout, containerName, owner.GetNamespace(), owner.GetName(), digest, imageIDs)
+	}
+	return err
+}
+
+// podDeletionCostAnnotation is the native hint by which pods of lower cost are preferred on scale-in.
+const podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+
+// AssertDeletionCostHonored annotates the pods controlled by owner, all of which must be given in costs, with their
+// deletion costs, and scales owner in one replica at a time until one pod is left. Each pod deleted is verified to
+// have the lowest cost among the remaining ones, and the actual deletion order is reported on mismatch. The test is
+// skipped for CollaSets, whose scale-in order does not take the deletion cost into account.
+func (f *Framework) AssertDeletionCostHonored(owner client.Object, costs map[string]int, timeout time.Duration) error {
+	if _, ok := owner.(*appsv1alpha1.CollaSet); ok {
+		ginkgo.Skip(fmt.Sprintf("CollaSet does not honor %s on scale-in", podDeletionCostAnnotation))
+	}
+	pods, err := f.listOwnedPods(owner)
+	if err != nil {
+		return err
+	}
+	remaining := map[string]int{}
+	for i := range pods {
+		cost, ok := costs[pods[i].Name]
+		if !ok {
+			return fmt.Errorf("no deletion cost is given for pod %s/%s of %s/%s", pods[i].Namespace, pods[i].Name, owner.GetNamespace(), owner.GetName())
+		}
+		remaining[pods[i].Name] = cost
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"%d"}}}`, podDeletionCostAnnotation, cost)
+		if err := f.Client.Patch(context.TODO(), &pods[i], client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+			return err
+		}
+	}
+
+	var order []string
+	for len(remaining) > 1 {
+		replicas := len(remaining) - 1
+		Logf("Scaling %s/%s in to %d replicas", owner.GetNamespace(), owner.GetName(), replicas)
+		patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+		if err := f.Client.Patch(context.TODO(), owner, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+			return err
+		}
+
+		var deleted string
+		err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+			pods, err := f.listOwnedPods(owner)
+			if err != nil {
+				return false, err
+			}
+			alive := sets.NewString()
+			for i := range pods {
+				if pods[i].DeletionTimestamp == nil {
+					alive.Insert(pods[i].Name)
+				}
+			}
+			for name := range remaining {
+				if !alive.Has(name) {
+					deleted = name
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err == wait.ErrWaitTimeout {
+			return fmt.Errorf("gave up after waiting %v for %s/%s to scale in to %d replicas, deletion order %v",
+				timeout, owner.GetNamespace(), owner.GetName(), replicas, order)
+		}
+		if err != nil {
+			return err
+		}
+
+		order = append(order, fmt.Sprintf("%s(cost=%d)", deleted, remaining[deleted]))
+		for name, cost := range remaining {
+			if cost < remaining[deleted] {
+				return fmt.Errorf("%s/%s deleted pod %s before pod %s of lower cost %d, deletion order %v",
+					owner.GetNamespace(), owner.GetName(), deleted, name, cost, order)
+			}
+		}
+		delete(remaining, deleted)
+	}
+	Logf("%s/%s deleted pods in order %v", owner.GetNamespace(), owner.GetName(), order)
+	return nil
+}
+
+// AssertZoneBalanced waits until all the replicas of owner are scheduled such that the skew of the pod counts per
+// zone, the topology.kubernetes.io/zone label of the nodes, does not exceed maxZoneSkew. Zones of schedulable and
+// untainted nodes without pods count as zero. The test is skipped if any such node lacks the zone label, and the
+// per-zone counts are reported on failure.
+func (f *Framework) AssertZoneBalanced(owner client.Object, maxZoneSkew int, timeout time.Duration) error {
+	nodeList, err := f.ClientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	nodeZones := map[string]string{}
+	schedulableZones := sets.NewString()
+	for _, node := range nodeList.Items {
+		zone, ok := node.Labels[corev1.LabelTopologyZone]
+		if ok {
+			nodeZones[node.Name] = zone
+		}
+		if !isNodeSchedulable(&node) || !isNodeUntainted(&node) {
+			continue
+		}
+		if !ok {
+			ginkgo.Skip(fmt.Sprintf("node %s has no %s label", node.Name, corev1.LabelTopologyZone))
+		}
+		schedulableZones.Insert(zone)
+	}
+
+	var counts map[string]int
+	var scheduled int
+	var replicas int64
+	err = wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(owner)
+		if err != nil {
+			return false, err
+		}
+		if replicas, err = nestedInt64(owner, "spec", "replicas"); err != nil {
+			return false, err
+		}
+		counts = map[string]int{}
+		for _, zone := range schedulableZones.List() {
+			counts[zone] = 0
+		}
+		scheduled = 0
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+				continue
+			}
+			scheduled++
+			counts[nodeZones[pod.Spec.NodeName]]++
+		}
+		if int64(scheduled) != replicas {
+			return false, nil
+		}
+		min, max := -1, 0
+		for _, count := range counts {
+			if min < 0 || count < min {
+				min = count
+			}
+			if count > max {
+				max = count
+			}
+		}
+		return max-min <= maxZoneSkew, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for %d of %d pods of %s/%s to be balanced across zones with skew at most %d, pods per zone %v",
+			timeout, scheduled, replicas, owner.GetNamespace(), owner.GetName(), maxZoneSkew, counts)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	appsv1alpha1 "kusionstack.io/operating/apis/apps/v1alpha1"
+	"kusionstack.io/operating/pkg/controllers/utils/poddecoration"
+)
+
+// CreateCollaSetWithDecoration creates a PodDecoration with the given spec and a CollaSet sharing its selector, and
+// waits up to PodStartTimeout until all the pods of the CollaSet are ready and decorated by the PodDecoration.
+func (f *Framework) CreateCollaSetWithDecoration(csName, pdName string, replicas int32, template corev1.PodTemplateSpec,
+	decoration appsv1alpha1.PodDecorationSpec) error {
+	cls := f.NewCollaSet(csName, replicas, template)
+	pd := &appsv1alpha1.PodDecoration{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: f.Namespace.Name,
+			Name:      pdName,
+		},
+		Spec: decoration,
+	}
+	pd.Spec.Selector = cls.Spec.Selector.DeepCopy()
+
+	// the PodDecoration is created first, so that the pods are decorated once created
+	Logf("Creating PodDecoration %s/%s", pd.Namespace, pd.Name)
+	if err := f.Client.Create(context.TODO(), pd); err != nil {
+		return err
+	}
+	if _, err := f.CreateCollaSetAndWaitReady(cls, PodStartTimeout); err != nil {
+		return err
+	}
+
+	Logf("Waiting up to %v for pods of CollaSet %s/%s to be decorated by PodDecoration %s", PodStartTimeout, cls.Namespace, csName, pdName)
+	var undecorated []string
+	err := wait.PollImmediate(Poll, PodStartTimeout, func() (bool, error) {
+		pods, err := f.listOwnedPods(cls)
+		if err != nil {
+			return false, err
+		}
+		undecorated = nil
+		for i := range pods {
+			if poddecoration.GetDecorationRevisionInfo(&pods[i]).GetRevision(pdName) == nil {
+				undecorated = append(undecorated, pods[i].Name)
+			}
+		}
+		return len(pods) == int(replicas) && len(undecorated) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", pd)
+		return fmt.Errorf("gave up after waiting %v for pods of CollaSet %s/%s to be decorated by PodDecoration %s, undecorated pods %v",
+			PodStartTimeout, cls.Namespace, csName, pdName, undecorated)
+	}
+	return err
+}
+
+// AssertDecorationMergeOrder waits until all the expected containers are injected into the pod, and verifies they
+// appear in the expected order, which is dictated by the weights of the PodDecorations merged into the pod. The
+// actual order of all the containers is reported on mismatch.
+func (f *Framework) AssertDecorationMergeOrder(podName string, expectedContainerOrder []string, timeout time.Duration) error {
+	expected := sets.NewString(expectedContainerOrder...)
+	var actual []string
+	var mismatched bool
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, "decorated", timeout, func(pod *corev1.Pod) (bool, error) {
+		actual = nil
+		var injected []string
+		for _, c := range pod.Spec.Containers {
+			actual = append(actual, c.Name)
+			if expected.Has(c.Name) {
+				injected = append(injected, c.Name)
+			}
+		}
+		if len(injected) < len(expectedContainerOrder) {
+			return false, nil
+		}
+		for i := range expectedContainerOrder {
+			if injected[i] != expectedContainerOrder[i] {
+				// WaitForPodCondition stops with the error only once done
+				mismatched = true
+				return true, fmt.Errorf("containers of pod %s/%s are in order %v, expected %v, decorations %s", pod.Namespace, pod.Name,
+					actual, expectedContainerOrder, pod.Annotations[appsv1alpha1.AnnotationPodDecorationRevision])
+			}
+		}
+		return true, nil
+	})
+	if err != nil && !mismatched && len(actual) > 0 {
+		return fmt.Errorf("%v, containers %v", err, actual)
+	}
+	return err
+}
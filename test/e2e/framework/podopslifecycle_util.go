@@ -0,0 +1,566 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1alpha1 "kusionstack.io/operating/apis/apps/v1alpha1"
+	"kusionstack.io/operating/pkg/controllers/utils/podopslifecycle"
+)
+
+// lifecycleFinalizerName returns the full name of the PodOpsLifecycle protection finalizer with the given ID,
+// which may be already prefixed.
+func lifecycleFinalizerName(finalizerID string) string {
+	if strings.HasPrefix(finalizerID, appsv1alpha1.PodOperationProtectionFinalizerPrefix+"/") {
+		return finalizerID
+	}
+	return appsv1alpha1.PodOperationProtectionFinalizerPrefix + "/" + finalizerID
+}
+
+// WaitForLifecycleFinalizer waits until the presence of the PodOpsLifecycle protection finalizer
+// prot.podopslifecycle.kusionstack.io/<finalizerID> on the pod matches present.
+func (f *Framework) WaitForLifecycleFinalizer(podName, finalizerID string, present bool, timeout time.Duration) error {
+	finalizer := lifecycleFinalizerName(finalizerID)
+	desc := "without finalizer " + finalizer
+	if present {
+		desc = "with finalizer " + finalizer
+	}
+
+	var finalizers []string
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, desc, timeout, func(pod *corev1.Pod) (bool, error) {
+		finalizers = pod.Finalizers
+		for _, fin := range pod.Finalizers {
+			if fin == finalizer {
+				return present, nil
+			}
+		}
+		return !present, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%v, finalizers %v", err, finalizers)
+	}
+	return nil
+}
+
+// AssertDeletionBlockedWhileFinalizer deletes the pod carrying the finalizer, and verifies the pod remains with its
+// deletionTimestamp set for blockFor. It then removes the finalizer, and waits up to PodDeleteTimeout until the pod
+// is gone.
+func (f *Framework) AssertDeletionBlockedWhileFinalizer(podName, finalizer string, blockFor time.Duration) error {
+	pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !sets.NewString(pod.Finalizers...).Has(finalizer) {
+		return fmt.Errorf("pod %s/%s has no finalizer %s, finalizers %v", pod.Namespace, podName, finalizer, pod.Finalizers)
+	}
+
+	Logf("Deleting pod %s/%s, and verifying it is blocked by finalizer %s for %v", pod.Namespace, podName, finalizer, blockFor)
+	if err := f.ClientSet.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	start := time.Now()
+	err = wait.PollImmediate(Poll, blockFor, func() (bool, error) {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("pod %s/%s is deleted after %v while finalizer %s is expected to block", f.Namespace.Name, podName,
+				time.Since(start).Round(time.Millisecond), finalizer)
+		}
+		if err != nil {
+			return false, err
+		}
+		if pod.DeletionTimestamp == nil {
+			return false, fmt.Errorf("pod %s/%s has no deletionTimestamp after deletion", pod.Namespace, podName)
+		}
+		if !sets.NewString(pod.Finalizers...).Has(finalizer) {
+			return false, fmt.Errorf("finalizer %s is removed from pod %s/%s after %v, finalizers %v", finalizer, pod.Namespace, podName,
+				time.Since(start).Round(time.Millisecond), pod.Finalizers)
+		}
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	Logf("Removing finalizer %s from pod %s/%s", finalizer, f.Namespace.Name, podName)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var finalizers []string
+		for _, fin := range pod.Finalizers {
+			if fin != finalizer {
+				finalizers = append(finalizers, fin)
+			}
+		}
+		pod.Finalizers = finalizers
+		_, err = f.ClientSet.CoreV1().Pods(pod.Namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var finalizers []string
+	err = wait.PollImmediate(Poll, PodDeleteTimeout, func() (bool, error) {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		finalizers = pod.Finalizers
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pod %s/%s to be deleted once finalizer %s is removed, finalizers %v",
+			PodDeleteTimeout, f.Namespace.Name, podName, finalizer, finalizers)
+	}
+	return err
+}
+
+// podOpsLifecycleStatePrefixes maps the PodOpsLifecycle states to the label prefixes which indicate them.
+var podOpsLifecycleStatePrefixes = map[string]string{
+	"pre-check":    appsv1alpha1.PodPreCheckLabelPrefix,
+	"pre-checked":  appsv1alpha1.PodPreCheckedLabelPrefix,
+	"preparing":    appsv1alpha1.PodPreparingLabelPrefix,
+	"operate":      appsv1alpha1.PodOperateLabelPrefix,
+	"operated":     appsv1alpha1.PodOperatedLabelPrefix,
+	"post-check":   appsv1alpha1.PodPostCheckLabelPrefix,
+	"post-checked": appsv1alpha1.PodPostCheckedLabelPrefix,
+	"completing":   appsv1alpha1.PodCompletingLabelPrefix,
+	"done":         appsv1alpha1.PodDoneOperationTypeLabelPrefix,
+}
+
+// lifecycleLabels returns the PodOpsLifecycle related labels of the pod formatted as sorted key=value pairs.
+func lifecycleLabels(pod *corev1.Pod) []string {
+	var labels []string
+	for k, v := range pod.Labels {
+		if strings.Contains(k, "podopslifecycle.kusionstack.io") {
+			labels = append(labels, k+"="+v)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// lifecycleIDsOfType returns the IDs of the operations of the given type labeled on the pod.
+// The "done" state is looked up through the done-operation-type labels, since the operation-type labels
+// are removed once the operation finishes.
+func lifecycleIDsOfType(pod *corev1.Pod, operationType, state string) []string {
+	typePrefix := appsv1alpha1.PodOperationTypeLabelPrefix
+	if state == "done" {
+		typePrefix = appsv1alpha1.PodDoneOperationTypeLabelPrefix
+	}
+	var ids []string
+	for k, v := range pod.Labels {
+		if strings.HasPrefix(k, typePrefix+"/") && v == operationType {
+			ids = append(ids, strings.TrimPrefix(k, typePrefix+"/"))
+		}
+	}
+	return ids
+}
+
+// WaitForPodOpsLifecycleState waits until an operation of the given type on the pod reaches the state, which is
+// one of pre-check, pre-checked, preparing, operate, operated, post-check, post-checked, completing and done.
+// All the lifecycle labels of the pod are dumped on timeout.
+func (f *Framework) WaitForPodOpsLifecycleState(podName, operationType, state string, timeout time.Duration) error {
+	statePrefix, ok := podOpsLifecycleStatePrefixes[state]
+	if !ok {
+		return fmt.Errorf("unknown PodOpsLifecycle state %q", state)
+	}
+
+	var labels []string
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, fmt.Sprintf("operation %s in state %s", operationType, state), timeout, func(pod *corev1.Pod) (bool, error) {
+		labels = lifecycleLabels(pod)
+		for _, id := range lifecycleIDsOfType(pod, operationType, state) {
+			if _, ok := pod.Labels[statePrefix+"/"+id]; ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%v, lifecycle labels [%s]", err, strings.Join(labels, ", "))
+	}
+	return nil
+}
+
+// testLifecycleAdapter is the LifecycleAdapter on behalf of which tests drive the PodOpsLifecycle of pods.
+type testLifecycleAdapter struct {
+	id            string
+	operationType podopslifecycle.OperationType
+}
+
+// GetID indicates ID of one PodOpsLifecycle
+func (a *testLifecycleAdapter) GetID() string {
+	return a.id
+}
+
+// GetType indicates type for an Operator
+func (a *testLifecycleAdapter) GetType() podopslifecycle.OperationType {
+	return a.operationType
+}
+
+// AllowMultiType indicates whether multiple IDs which have the same Type are allowed
+func (a *testLifecycleAdapter) AllowMultiType() bool {
+	return true
+}
+
+// WhenBegin will be executed when begin a lifecycle
+func (a *testLifecycleAdapter) WhenBegin(_ client.Object) (bool, error) {
+	return false, nil
+}
+
+// WhenFinish will be executed when finish a lifecycle
+func (a *testLifecycleAdapter) WhenFinish(_ client.Object) (bool, error) {
+	return false, nil
+}
+
+// updatePodOpsLifecycle gets the pod and applies fn with the adapter of the operator, retrying on conflict.
+func (f *Framework) updatePodOpsLifecycle(podName, operationType, operatorID string,
+	fn func(c client.Client, adapter podopslifecycle.LifecycleAdapter, obj client.Object, updateFunc ...podopslifecycle.UpdateFunc) (bool, error)) error {
+	adapter := &testLifecycleAdapter{id: operatorID, operationType: podopslifecycle.OperationType(operationType)}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod := &corev1.Pod{}
+		if err := f.Client.Get(context.TODO(), client.ObjectKey{Namespace: f.Namespace.Name, Name: podName}, pod); err != nil {
+			return err
+		}
+		_, err := fn(f.Client, adapter, pod)
+		return err
+	})
+}
+
+// BeginPodOpsLifecycle begins an operation of the given type on the pod on behalf of the operator, by labeling
+// the pod with operating/<operatorID> and operation-type/<operatorID> as a CRD operator would.
+func (f *Framework) BeginPodOpsLifecycle(podName, operationType, operatorID string) error {
+	Logf("Beginning PodOpsLifecycle %s of pod %s/%s by %s", operationType, f.Namespace.Name, podName, operatorID)
+	return f.updatePodOpsLifecycle(podName, operationType, operatorID, podopslifecycle.Begin)
+}
+
+// FinishPodOpsLifecycle finishes the operation of the given type on the pod begun by BeginPodOpsLifecycle.
+func (f *Framework) FinishPodOpsLifecycle(podName, operationType, operatorID string) error {
+	Logf("Finishing PodOpsLifecycle %s of pod %s/%s by %s", operationType, f.Namespace.Name, podName, operatorID)
+	return f.updatePodOpsLifecycle(podName, operationType, operatorID, podopslifecycle.Finish)
+}
+
+// readyEndpointSlicesOfPod returns the EndpointSlices of the services selecting the pod which still list it
+// as a ready endpoint.
+func (f *Framework) readyEndpointSlicesOfPod(pod *corev1.Pod) ([]string, error) {
+	serviceList, err := f.ClientSet.CoreV1().Services(pod.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var slices []string
+	for _, svc := range serviceList.Items {
+		if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		sliceList, err := f.ClientSet.DiscoveryV1().EndpointSlices(pod.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: svc.Name}).String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, slice := range sliceList.Items {
+			for _, ep := range slice.Endpoints {
+				if ep.TargetRef == nil || ep.TargetRef.UID != pod.UID {
+					continue
+				}
+				if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+					slices = append(slices, slice.Name)
+				}
+			}
+		}
+	}
+	return slices, nil
+}
+
+// AssertPodServiceAvailable waits until the presence of the service-available label on the pod matches available.
+// When available is false, it also waits until no EndpointSlice of the services selecting the pod lists it as a
+// ready endpoint, so that the traffic is drained from the pod.
+func (f *Framework) AssertPodServiceAvailable(podName string, available bool, timeout time.Duration) error {
+	desc := "without label " + appsv1alpha1.PodServiceAvailableLabel
+	if available {
+		desc = "with label " + appsv1alpha1.PodServiceAvailableLabel
+	}
+
+	start := time.Now()
+	var pod *corev1.Pod
+	err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, desc, timeout, func(p *corev1.Pod) (bool, error) {
+		pod = p
+		_, ok := p.Labels[appsv1alpha1.PodServiceAvailableLabel]
+		return ok == available, nil
+	})
+	if err != nil {
+		if pod != nil {
+			return fmt.Errorf("%v, lifecycle labels [%s]", err, strings.Join(lifecycleLabels(pod), ", "))
+		}
+		return err
+	}
+	if available {
+		return nil
+	}
+
+	remaining := timeout - time.Since(start)
+	var slices []string
+	err = wait.PollImmediate(Poll, remaining, func() (bool, error) {
+		slices, err = f.readyEndpointSlicesOfPod(pod)
+		if err != nil {
+			return false, err
+		}
+		return len(slices) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pod %s/%s to be removed from EndpointSlices %v", timeout, pod.Namespace, pod.Name, slices)
+	}
+	return err
+}
+
+// AssertAvailabilityFollowsReadiness watches the pod until it is labeled service-available, and fails if the label
+// is observed while the PodReady condition is not True, reporting the times of both transitions.
+func (f *Framework) AssertAvailabilityFollowsReadiness(podName string, timeout time.Duration) error {
+	Logf("Watching up to %v for pod %s/%s to be service-available after ready", timeout, f.Namespace.Name, podName)
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+	w, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", podName).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var readyAt time.Time
+	_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, fmt.Errorf("pod %s/%s was deleted before being service-available", f.Namespace.Name, podName)
+		}
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			return false, nil
+		}
+
+		cond := getPodReadyCondition(pod)
+		ready := cond != nil && cond.Status == corev1.ConditionTrue
+		if ready && readyAt.IsZero() {
+			readyAt = cond.LastTransitionTime.Time
+		}
+		if _, available := pod.Labels[appsv1alpha1.PodServiceAvailableLabel]; !available {
+			return false, nil
+		}
+		if !ready {
+			readyDesc := "never"
+			if cond != nil {
+				readyDesc = fmt.Sprintf("%s at %s", cond.Status, cond.LastTransitionTime.Format(time.RFC3339))
+			}
+			return false, fmt.Errorf("pod %s/%s is labeled %s at %s before it is ready, PodReady %s",
+				pod.Namespace, pod.Name, appsv1alpha1.PodServiceAvailableLabel, time.Now().Format(time.RFC3339Nano), readyDesc)
+		}
+		Logf("Pod %s/%s is ready at %s, and service-available at %s", pod.Namespace, pod.Name,
+			readyAt.Format(time.RFC3339), time.Now().Format(time.RFC3339Nano))
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for pod %s/%s to be service-available", timeout, f.Namespace.Name, podName)
+	}
+	return err
+}
+
+// AssertNoStuckLifecycleOperations sweeps the pods in the framework namespace, and fails if any of them carries
+// an operating label, whose value is the time the operation began, older than timeout without reaching the
+// completing state. The stuck operations are reported with their age.
+func (f *Framework) AssertNoStuckLifecycleOperations(timeout time.Duration) error {
+	podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var stuck []string
+	for _, pod := range podList.Items {
+		for k, v := range pod.Labels {
+			if !strings.HasPrefix(k, appsv1alpha1.PodOperatingLabelPrefix+"/") {
+				continue
+			}
+			id := strings.TrimPrefix(k, appsv1alpha1.PodOperatingLabelPrefix+"/")
+			if _, ok := pod.Labels[appsv1alpha1.PodCompletingLabelPrefix+"/"+id]; ok {
+				continue
+			}
+			began, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("pod %s/%s has invalid label %s=%s: %v", pod.Namespace, pod.Name, k, v, err)
+			}
+			if age := now.Sub(time.Unix(0, began)); age > timeout {
+				stuck = append(stuck, fmt.Sprintf("%s(id=%s, type=%s, age=%v)", pod.Name, id,
+					pod.Labels[appsv1alpha1.PodOperationTypeLabelPrefix+"/"+id], age.Round(time.Second)))
+			}
+		}
+	}
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("%d PodOpsLifecycle operations are stuck for more than %v: [%s]", len(stuck), timeout, strings.Join(stuck, ", "))
+	}
+	return nil
+}
+
+// LifecycleHookSpec configures a CollaSet built by NewCollaSetWithLifecycle.
+type LifecycleHookSpec struct {
+	// Template is the pod template, whose labels default to app=<name>.
+	Template corev1.PodTemplateSpec
+	// PodUpdatePolicy is the policy by which the pods are updated, defaulted by the webhook if empty.
+	PodUpdatePolicy appsv1alpha1.PodUpdateStrategyType
+	// UpdateOperationDelaySeconds delays operating pods to update once they are allowed to.
+	UpdateOperationDelaySeconds *int32
+	// ScaleInOperationDelaySeconds delays operating pods to scale in once they are allowed to.
+	ScaleInOperationDelaySeconds *int32
+}
+
+// NewCollaSetWithLifecycle builds a CollaSet whose pods take part in PodOpsLifecycle, i.e. they are labeled as
+// controlled by KusionStack and declare the service-ready readiness gate, with the operation delays of hooks.
+func (f *Framework) NewCollaSetWithLifecycle(name string, replicas int32, hooks LifecycleHookSpec) *appsv1alpha1.CollaSet {
+	cls := f.NewCollaSet(name, replicas, hooks.Template)
+
+	template := &cls.Spec.Template
+	template.Labels[appsv1alpha1.ControlledByKusionStackLabelKey] = "true"
+	hasGate := false
+	for _, gate := range template.Spec.ReadinessGates {
+		hasGate = hasGate || gate.ConditionType == appsv1alpha1.ReadinessGatePodServiceReady
+	}
+	if !hasGate {
+		template.Spec.ReadinessGates = append(template.Spec.ReadinessGates, corev1.PodReadinessGate{
+			ConditionType: appsv1alpha1.ReadinessGatePodServiceReady,
+		})
+	}
+
+	cls.Spec.UpdateStrategy.PodUpdatePolicy = hooks.PodUpdatePolicy
+	cls.Spec.UpdateStrategy.OperationDelaySeconds = hooks.UpdateOperationDelaySeconds
+	cls.Spec.ScaleStrategy.OperationDelaySeconds = hooks.ScaleInOperationDelaySeconds
+	return cls
+}
+
+// flipBackOperationType and flipBackOperatorID identify the PodOpsLifecycle by which AssertReadinessGateCanFlipBack
+// re-blocks the pod.
+const (
+	flipBackOperationType = "e2e-flip-back"
+	flipBackOperatorID    = "e2e-flip-back"
+)
+
+// AssertReadinessGateCanFlipBack verifies the gate condition of the pod is managed in both directions: it waits
+// until the condition is True, begins a PodOpsLifecycle on the pod and waits until the condition turns False when
+// the pod is preparing, then finishes the lifecycle and waits until it is True again. The PodTransitionRules of the
+// pod must let it pass the pre-check. The observed transitions of the condition are reported on failure.
+func (f *Framework) AssertReadinessGateCanFlipBack(podName, conditionType string, timeout time.Duration) error {
+	var transitions []string
+	lastStatus := corev1.ConditionStatus("")
+	waitForGate := func(status corev1.ConditionStatus) error {
+		err := WaitForPodCondition(f.ClientSet, f.Namespace.Name, podName, fmt.Sprintf("gate %s %s", conditionType, status), timeout, func(pod *corev1.Pod) (bool, error) {
+			for _, cond := range pod.Status.Conditions {
+				if string(cond.Type) != conditionType {
+					continue
+				}
+				if cond.Status != lastStatus {
+					transitions = append(transitions, fmt.Sprintf("%s at %s", cond.Status, cond.LastTransitionTime.Format(time.RFC3339)))
+					lastStatus = cond.Status
+				}
+				return cond.Status == status, nil
+			}
+			return false, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%v, observed transitions [%s]", err, strings.Join(transitions, ", "))
+		}
+		return nil
+	}
+
+	if err := waitForGate(corev1.ConditionTrue); err != nil {
+		return err
+	}
+	if err := f.BeginPodOpsLifecycle(podName, flipBackOperationType, flipBackOperatorID); err != nil {
+		return err
+	}
+	if err := waitForGate(corev1.ConditionFalse); err != nil {
+		return err
+	}
+	if err := f.FinishPodOpsLifecycle(podName, flipBackOperationType, flipBackOperatorID); err != nil {
+		return err
+	}
+	if err := waitForGate(corev1.ConditionTrue); err != nil {
+		return err
+	}
+	Logf("Gate %s of pod %s/%s flipped back, transitions [%s]", conditionType, f.Namespace.Name, podName, strings.Join(transitions, ", "))
+	return nil
+}
+
+// WaitForOperationAllowed waits until an operation of the given type on the pod is allowed to proceed, i.e. it is
+// labeled operate.podopslifecycle.kusionstack.io/<id> once its pre-check and preparing pass, which is how AllowOps
+// decides. All the lifecycle labels of the pod are dumped on timeout.
+func (f *Framework) WaitForOperationAllowed(podName, operationType string, timeout time.Duration) error {
+	return f.WaitForPodOpsLifecycleState(podName, operationType, "operate", timeout)
+}
+
+// WaitForOperationDenied verifies an operation of the given type on the pod is in progress but is not allowed to
+// proceed throughout the timeout, e.g. because a PodTransitionRule rejects it in pre-check. It fails as soon as the
+// operation is allowed or is no longer in progress, dumping all the lifecycle labels of the pod.
+func (f *Framework) WaitForOperationDenied(podName, operationType string, timeout time.Duration) error {
+	Logf("Verifying operation %s of pod %s/%s is denied for %v", operationType, f.Namespace.Name, podName, timeout)
+	var labels []string
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		labels = lifecycleLabels(pod)
+		ids := lifecycleIDsOfType(pod, operationType, "operate")
+		if len(ids) == 0 {
+			return false, fmt.Errorf("no operation %s is in progress on pod %s/%s", operationType, f.Namespace.Name, podName)
+		}
+		for _, id := range ids {
+			if _, ok := pod.Labels[appsv1alpha1.PodOperateLabelPrefix+"/"+id]; ok {
+				return false, fmt.Errorf("operation %s of pod %s/%s by %s is allowed", operationType, f.Namespace.Name, podName, id)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return fmt.Errorf("%v, lifecycle labels [%s]", err, strings.Join(labels, ", "))
+}
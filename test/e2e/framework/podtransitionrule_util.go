@@ -0,0 +1,59 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1alpha1 "kusionstack.io/operating/apis/apps/v1alpha1"
+)
+
+// DisableTransitionRulesForPods disables all the rules of the PodTransitionRules in the framework namespace
+// which target any of the given pods.
+func (f *Framework) DisableTransitionRulesForPods(podNames ...string) error {
+	ruleList := &appsv1alpha1.PodTransitionRuleList{}
+	if err := f.Client.List(context.TODO(), ruleList, client.InNamespace(f.Namespace.Name)); err != nil {
+		return err
+	}
+
+	pods := sets.NewString(podNames...)
+	for i := range ruleList.Items {
+		rule := &ruleList.Items[i]
+		if !pods.HasAny(rule.Status.Targets...) {
+			continue
+		}
+
+		Logf("Disabling rules of PodTransitionRule %s/%s", rule.Namespace, rule.Name)
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if err := f.refreshObject(rule); err != nil {
+				return err
+			}
+			for j := range rule.Spec.Rules {
+				rule.Spec.Rules[j].Disabled = true
+			}
+			return f.Client.Update(context.TODO(), rule)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// gatherHeapProfile saves a heap profile of the controller-manager from its pprof endpoint into the report
+// directory, or the output directory if it is not set, and returns the path of the profile.
+func (f *Framework) gatherHeapProfile() (string, error) {
+	localPort, stop, err := f.portForwardControllerManager(TestContext.ControllerManagerPprofPort)
+	if err != nil {
+		return "", err
+	}
+	defer stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/pprof/heap", localPort))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("pprof is not served by the controller-manager: %s", resp.Status)
+	}
+
+	dir := TestContext.ReportDir
+	if dir == "" {
+		dir = TestContext.OutputDir
+	}
+	filePath := path.Join(dir, fmt.Sprintf("heap_%s_%s.pprof", f.UniqueName, time.Now().Format(time.RFC3339)))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// profileIfSlow gathers a heap profile of the controller-manager if the current test has been running for longer
+// than TestContext.ProfileSlowTestsThreshold. It is skipped gracefully if pprof is not exposed.
+func (f *Framework) profileIfSlow() {
+	if TestContext.ProfileSlowTestsThreshold <= 0 || !TestContext.AllowGatheringProfiles || f.testStartTime.IsZero() {
+		return
+	}
+	elapsed := time.Since(f.testStartTime)
+	if elapsed < TestContext.ProfileSlowTestsThreshold {
+		return
+	}
+
+	Logf("Test took %v, longer than %v, gathering a heap profile of the controller-manager", elapsed, TestContext.ProfileSlowTestsThreshold)
+	filePath, err := f.gatherHeapProfile()
+	if err != nil {
+		Logf("Skipping gathering heap profile of the controller-manager: %v", err)
+		return
+	}
+	Logf("Heap profile of the controller-manager saved to %s", filePath)
+}
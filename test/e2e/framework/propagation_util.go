@@ -0,0 +1,126 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// PropagationSummary is a TestDataSummary of how fast the controller brings up the pods of a new CollaSet.
+type PropagationSummary struct {
+	CollaSet         string        `json:"collaSet"`
+	Replicas         int32         `json:"replicas"`
+	CreateToFirstPod time.Duration `json:"createToFirstPod"`
+	CreateToAllReady time.Duration `json:"createToAllReady"`
+}
+
+// SummaryKind returns the kind of the summary.
+func (s *PropagationSummary) SummaryKind() string {
+	return "PropagationSummary"
+}
+
+// PrintHumanReadable prints the summary in human readable form.
+func (s *PropagationSummary) PrintHumanReadable() string {
+	return fmt.Sprintf("CollaSet %s with %d replicas: first pod created after %v, all pods ready after %v",
+		s.CollaSet, s.Replicas, s.CreateToFirstPod, s.CreateToAllReady)
+}
+
+// PrintJSON prints the summary in JSON.
+func (s *PropagationSummary) PrintJSON() string {
+	return summaryJSON(s.SummaryKind(), s)
+}
+
+// propagationReplicas and propagationPodImage shape the CollaSet created by MeasurePropagation.
+const (
+	propagationReplicas = int32(3)
+	propagationPodImage = "registry.k8s.io/pause:3.9"
+)
+
+// MeasurePropagation creates a CollaSet of the given name with propagationReplicas pause pods in the framework
+// namespace, and measures by watching its pods how long it takes until the first pod is created and until all the
+// pods are ready. A PropagationSummary is recorded into TestSummaries.
+func (f *Framework) MeasurePropagation(name string) (createToFirstPod, createToAllReady time.Duration, err error) {
+	replicas := propagationReplicas
+	cls := f.NewCollaSet(name, replicas, corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "pause", Image: propagationPodImage}}},
+	})
+
+	// watch from before the creation, so that no pod is missed
+	podList, err := f.ClientSet.CoreV1().Pods(cls.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), PodStartTimeout)
+	defer cancel()
+	w, err := f.ClientSet.CoreV1().Pods(cls.Namespace).Watch(ctx, metav1.ListOptions{ResourceVersion: podList.ResourceVersion})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := time.Now()
+	if err := f.Client.Create(context.TODO(), cls); err != nil {
+		w.Stop()
+		return 0, 0, err
+	}
+	f.TrackObject(cls)
+
+	ready := map[string]bool{}
+	_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok || !isOwnedBy(pod, cls) {
+			return false, nil
+		}
+		if createToFirstPod == 0 {
+			createToFirstPod = time.Since(start)
+		}
+		cond := getPodReadyCondition(pod)
+		ready[pod.Name] = event.Type != watch.Deleted && cond != nil && cond.Status == corev1.ConditionTrue
+		var readyCount int32
+		for _, r := range ready {
+			if r {
+				readyCount++
+			}
+		}
+		if readyCount >= replicas {
+			createToAllReady = time.Since(start)
+			return true, nil
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return createToFirstPod, 0, fmt.Errorf("gave up after waiting %v for %d pods of CollaSet %s/%s to be ready", PodStartTimeout, replicas, cls.Namespace, cls.Name)
+	}
+	if err != nil {
+		return createToFirstPod, 0, err
+	}
+
+	f.TestSummaries = append(f.TestSummaries, &PropagationSummary{
+		CollaSet:         cls.Namespace + "/" + cls.Name,
+		Replicas:         replicas,
+		CreateToFirstPod: createToFirstPod,
+		CreateToAllReady: createToAllReady,
+	})
+	return createToFirstPod, createToAllReady, nil
+}
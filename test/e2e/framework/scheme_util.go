@@ -0,0 +1,78 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cloneScheme copies the types known by the base scheme into a new scheme. Conversion and defaulting functions
+// are not copied, since they are not used by the clients.
+func cloneScheme(base *runtime.Scheme) *runtime.Scheme {
+	s := runtime.NewScheme()
+	unversioned := map[reflect.Type]bool{}
+	for gvk, t := range base.AllKnownTypes() {
+		obj, ok := reflect.New(t).Interface().(runtime.Object)
+		if !ok {
+			continue
+		}
+		if gvks, isUnversioned, err := base.ObjectKinds(obj); err == nil && isUnversioned {
+			if !unversioned[t] {
+				unversioned[t] = true
+				s.AddUnversionedTypes(gvks[0].GroupVersion(), obj)
+			}
+			continue
+		}
+		s.AddKnownTypeWithName(gvk, obj)
+	}
+	return s
+}
+
+// RegisterScheme extends a clone of the scheme of the framework client with the builder, and rebuilds the client
+// against it, keeping the interceptor installed by SetClientInterceptor, if any. Only the clients of this framework
+// are affected, not the package-global scheme.Scheme. Since the clients are recreated for each test, it should be
+// called in a BeforeEach after the framework is created.
+func (f *Framework) RegisterScheme(builder func(*runtime.Scheme) error) error {
+	if f.restConfig == nil {
+		return fmt.Errorf("clients of framework %s are not initialized yet", f.BaseName)
+	}
+	base := scheme.Scheme
+	if f.Client != nil {
+		base = f.Client.Scheme()
+	}
+	s := cloneScheme(base)
+	if err := builder(s); err != nil {
+		return err
+	}
+
+	c, err := client.New(f.restConfig, client.Options{Scheme: s})
+	if err != nil {
+		return err
+	}
+	// swap the client wrapped by the interceptor, so that its cleanup still restores the rebuilt client
+	if ic, ok := f.Client.(*interceptingClient); ok {
+		ic.Client = c
+		return nil
+	}
+	f.Client = c
+	return nil
+}
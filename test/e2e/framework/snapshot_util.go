@@ -0,0 +1,149 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+)
+
+// listOperatingResources returns the namespaced resources served in the *.kusionstack.io API groups which
+// can be listed.
+func (f *Framework) listOperatingResources() ([]schema.GroupVersionResource, error) {
+	resourceLists, err := f.ClientSet.Discovery().ServerPreferredNamespacedResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	var gvrs []schema.GroupVersionResource
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		if gv.Group != operatingGroupSuffix && !strings.HasSuffix(gv.Group, "."+operatingGroupSuffix) {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			if !strings.Contains(resource.Name, "/") && sets.NewString(resource.Verbs...).Has("list") {
+				gvrs = append(gvrs, gv.WithResource(resource.Name))
+			}
+		}
+	}
+	return gvrs, nil
+}
+
+// writeSnapshotFile writes the object into the file in dir, encoded in JSON unless it is already bytes.
+func writeSnapshotFile(dir, name string, obj interface{}) error {
+	data, ok := obj.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.MarshalIndent(obj, "", "  "); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path.Join(dir, name), data, 0644)
+}
+
+// CollectClusterSnapshot dumps the operating custom resources, the pods with their status and the events in the
+// framework namespace, or all namespaces if there is none, together with the logs and metrics of the
+// controller-manager into dir, as a single archive for post-mortem. It keeps collecting on errors, which are
+// aggregated.
+func (f *Framework) CollectClusterSnapshot(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ns := metav1.NamespaceAll
+	if f.Namespace != nil {
+		ns = f.Namespace.Name
+	}
+	Logf("Collecting cluster snapshot of namespace %q into %s", ns, dir)
+
+	var errs []error
+	collect := func(name string, fn func() (interface{}, error)) {
+		obj, err := fn()
+		if err == nil {
+			err = writeSnapshotFile(dir, name, obj)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	gvrs, err := f.listOperatingResources()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, gvr := range gvrs {
+		gvr := gvr
+		collect(fmt.Sprintf("%s_%s.json", gvr.Group, gvr.Resource), func() (interface{}, error) {
+			return f.DynamicClient.Resource(gvr).Namespace(ns).List(context.TODO(), metav1.ListOptions{})
+		})
+	}
+
+	collect("pods.json", func() (interface{}, error) {
+		return f.ClientSet.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{})
+	})
+	collect("events.json", func() (interface{}, error) {
+		events, err := f.ClientSet.CoreV1().Events(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byFirstTimestamp(events.Items))
+		return events, nil
+	})
+
+	// the logs of the leader, which did the reconciling, are preferred over those of all the replicas
+	var cmPods []corev1.Pod
+	if leader, err := f.GetControllerLeaderPod(); err == nil {
+		cmPods = []corev1.Pod{*leader}
+	} else {
+		podList, err := f.ClientSet.CoreV1().Pods(TestContext.ControllerManagerNamespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: TestContext.ControllerManagerSelector,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			cmPods = podList.Items
+		}
+	}
+	for _, pod := range cmPods {
+		for _, c := range pod.Spec.Containers {
+			pod, c := pod, c
+			collect(fmt.Sprintf("controller-manager_%s_%s.log", pod.Name, c.Name), func() (interface{}, error) {
+				return f.ClientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: c.Name}).Do(context.TODO()).Raw()
+			})
+		}
+	}
+
+	collect("controller-manager_metrics.txt", func() (interface{}, error) {
+		return f.fetchControllerManagerMetrics()
+	})
+
+	return utilerrors.NewAggregate(errs)
+}
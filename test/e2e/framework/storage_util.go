@@ -0,0 +1,57 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"sync"
+
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreateStorageClass creates the cluster-scoped StorageClass, and returns a function deleting it, which is
+// also run in AfterEach. An existing StorageClass with the same name is reused and left untouched on restore.
+func (f *Framework) CreateStorageClass(name, provisioner string, params map[string]string) (restore func(), err error) {
+	sc := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: name},
+		Provisioner: provisioner,
+		Parameters:  params,
+	}
+	_, err = f.ClientSet.StorageV1().StorageClasses().Create(context.TODO(), sc, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		Logf("StorageClass %s already exists, reusing it", name)
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	restore = func() {
+		once.Do(func() {
+			Logf("Deleting StorageClass %s", name)
+			err := f.ClientSet.StorageV1().StorageClasses().Delete(context.TODO(), name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				Logf("Failed to delete StorageClass %s: %v", name, err)
+			}
+		})
+	}
+	f.addCleanup(restore)
+	return restore, nil
+}
@@ -146,6 +146,31 @@ type TestContextType struct {
 
 	// The DNS Domain of the cluster.
 	ClusterDNSDomain string
+
+	// ClientTimeout is the timeout of a single request sent by the framework clients, unless set by Options.
+	ClientTimeout time.Duration
+	// AllowForceDeleteNamespace allows stripping the finalizers of a namespace stuck terminating.
+	AllowForceDeleteNamespace bool
+	// AuditClientRequests enables logging every request sent by the framework clients, for debugging e.g. RBAC.
+	AuditClientRequests bool
+	// ClusterSnapshotDir is the directory the cluster snapshot is collected into after the suite, if set.
+	ClusterSnapshotDir string
+
+	// ControllerManagerNamespace is the namespace where the operating controller-manager is deployed.
+	ControllerManagerNamespace string
+	// ControllerManagerSelector is the label selector of the operating controller-manager pods.
+	ControllerManagerSelector string
+	// ControllerManagerMetricsPort is the port on which the controller-manager serves its metrics.
+	ControllerManagerMetricsPort int
+	// ControllerManagerHealthPort is the port on which the controller-manager serves its health probes.
+	ControllerManagerHealthPort int
+	// ControllerManagerPprofPort is the port on which the controller-manager serves pprof, if it is exposed.
+	ControllerManagerPprofPort int
+	// ProfileSlowTestsThreshold is the duration beyond which a heap profile of the controller-manager is gathered
+	// after a test. Zero disables it.
+	ProfileSlowTestsThreshold time.Duration
+	// ControllerManagerLeaderElectionID is the name of the leader election lease of the controller-manager.
+	ControllerManagerLeaderElectionID string
 }
 
 // NodeTestContextType is part of TestContextType, it is shared by all node e2e test.
@@ -216,6 +241,8 @@ func RegisterCommonFlags(flags *flag.FlagSet) {
 	flags.StringVar(&TestContext.LogexporterGCSPath, "logexporter-gcs-path", "", "Path to the GCS artifacts directory to dump logs from nodes. Logexporter gets enabled if this is non-empty.")
 	flags.BoolVar(&TestContext.DeleteNamespace, "delete-namespace", true, "If true tests will delete namespace after completion. It is only designed to make debugging easier, DO NOT turn it off by default.")
 	flags.BoolVar(&TestContext.DeleteNamespaceOnFailure, "delete-namespace-on-failure", true, "If true, framework will delete test namespace on failure. Used only during test debugging.")
+	flags.BoolVar(&TestContext.AllowForceDeleteNamespace, "allow-force-delete-namespace", false, "If true, framework may strip the finalizers of a namespace stuck terminating. This is destructive, use it only for a cluster dedicated to testing.")
+	flags.StringVar(&TestContext.ClusterSnapshotDir, "cluster-snapshot-dir", "", "If set, the operating resources, pods, events, and controller-manager logs and metrics are collected into this directory after the suite.")
 	flags.IntVar(&TestContext.AllowedNotReadyNodes, "allowed-not-ready-nodes", 0, "If non-zero, framework will allow for that many non-ready nodes when checking for all ready nodes.")
 
 	flags.StringVar(&TestContext.Host, "host", "", fmt.Sprintf("The host, or apiserver, to connect to. Will default to %s if this argument and --kubeconfig are not set", defaultHost))
@@ -242,6 +269,8 @@ func RegisterClusterFlags(flags *flag.FlagSet) {
 	TestContext.KubeConfig = kubeconfigFromEnv
 	flags.StringVar(&TestContext.KubeContext, clientcmd.FlagContext, "", "kubeconfig context to use/override. If unset, will use value from 'current-context'")
 	flags.StringVar(&TestContext.KubeAPIContentType, "kube-api-content-type", "application/json", "ContentType used to communicate with apiserver")
+	flags.BoolVar(&TestContext.AuditClientRequests, "audit-client-requests", false, "If true, the verb, path and status of every request sent by the framework clients are logged, with Authorization headers redacted.")
+	flags.DurationVar(&TestContext.ClientTimeout, "client-timeout", DefaultClientTimeout, "Timeout of a single request to the apiserver, distinct from the timeouts of polling for conditions.")
 
 	flags.StringVar(&TestContext.KubeVolumeDir, "volume-dir", "/var/lib/kubelet", "Path to the directory containing the kubelet volumes.")
 	flags.StringVar(&TestContext.CertDir, "cert-dir", "", "Path to the directory containing the certs. Default is empty, which doesn't use certs.")
@@ -256,6 +285,13 @@ func RegisterClusterFlags(flags *flag.FlagSet) {
 	flags.StringVar(&TestContext.ClusterMonitoringMode, "cluster-monitoring-mode", "standalone", "The monitoring solution that is used in the cluster.")
 	flags.BoolVar(&TestContext.EnablePrometheusMonitoring, "prometheus-monitoring", false, "Separate Prometheus monitoring deployed in cluster.")
 	flags.StringVar(&TestContext.ClusterDNSDomain, "dns-domain", "cluster.local", "The DNS Domain of the cluster.")
+	flags.StringVar(&TestContext.ControllerManagerNamespace, "controller-manager-namespace", "kusionstack-system", "The namespace where the operating controller-manager is deployed.")
+	flags.StringVar(&TestContext.ControllerManagerSelector, "controller-manager-selector", "control-plane in (controller-manager,kusionstack-operating)", "The label selector of the operating controller-manager pods, which matches both the kustomize and the chart installation by default.")
+	flags.IntVar(&TestContext.ControllerManagerMetricsPort, "controller-manager-metrics-port", 8080, "The port on which the operating controller-manager serves its metrics.")
+	flags.IntVar(&TestContext.ControllerManagerHealthPort, "controller-manager-health-port", 8081, "The port on which the operating controller-manager serves its health probes.")
+	flags.IntVar(&TestContext.ControllerManagerPprofPort, "controller-manager-pprof-port", 6060, "The port on which the operating controller-manager serves pprof, if it is exposed.")
+	flags.DurationVar(&TestContext.ProfileSlowTestsThreshold, "profile-slow-tests-threshold", 0, "If non-zero, a heap profile of the operating controller-manager is saved into the report directory after a test running longer than it.")
+	flags.StringVar(&TestContext.ControllerManagerLeaderElectionID, "controller-manager-leader-election-id", "kusionstack-controller-manager", "The name of the leader election lease of the operating controller-manager.")
 
 	// TODO: Flags per provider?  Rename gce-project/gce-zone?
 	cloudConfig := &TestContext.CloudConfig
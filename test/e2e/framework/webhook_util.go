@@ -0,0 +1,239 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// MutatingWebhookConfigurationName is the name of the mutating webhook configuration of operating.
+	MutatingWebhookConfigurationName = "kusionstack-controller-manager-mutating"
+	// ValidatingWebhookConfigurationName is the name of the validating webhook configuration of operating.
+	ValidatingWebhookConfigurationName = "kusionstack-controller-manager-validating"
+)
+
+// AssertCreateRejected attempts to create the object, and verifies the creation fails with a StatusError whose
+// message contains expectedMsgSubstring, e.g. as the validating webhook rejects an invalid CollaSet.
+// An object which is unexpectedly created is deleted again.
+func (f *Framework) AssertCreateRejected(obj client.Object, expectedMsgSubstring string) error {
+	err := f.Client.Create(context.TODO(), obj)
+	if err == nil {
+		if delErr := f.Client.Delete(context.TODO(), obj); delErr != nil && !apierrors.IsNotFound(delErr) {
+			Logf("Failed to delete unexpectedly created %s/%s: %v", obj.GetNamespace(), obj.GetName(), delErr)
+		}
+		return fmt.Errorf("expected creation of %s/%s to be rejected with %q, but it succeeded", obj.GetNamespace(), obj.GetName(), expectedMsgSubstring)
+	}
+
+	var statusErr *apierrors.StatusError
+	if !errors.As(err, &statusErr) {
+		return fmt.Errorf("expected creation of %s/%s to be rejected with a StatusError, got %T: %v", obj.GetNamespace(), obj.GetName(), err, err)
+	}
+	if !strings.Contains(statusErr.ErrStatus.Message, expectedMsgSubstring) {
+		return fmt.Errorf("expected creation of %s/%s to be rejected with %q, got %q", obj.GetNamespace(), obj.GetName(), expectedMsgSubstring, statusErr.ErrStatus.Message)
+	}
+	Logf("Creation of %s/%s rejected as expected: %s", obj.GetNamespace(), obj.GetName(), statusErr.ErrStatus.Message)
+	return nil
+}
+
+// createAndFetch creates the object, and returns a copy of it re-fetched from the server.
+func (f *Framework) createAndFetch(obj client.Object) (client.Object, error) {
+	if err := f.Client.Create(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	stored, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("unexpected copy of %T", obj)
+	}
+	if err := f.refreshObject(stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// AssertDefaulted creates the object, re-fetches it, and runs check on the stored version, so that tests can
+// assert exactly which fields the mutating webhook and the API server defaulted.
+func (f *Framework) AssertDefaulted(obj client.Object, check func(client.Object) error) error {
+	stored, err := f.createAndFetch(obj)
+	if err != nil {
+		return err
+	}
+	if err := check(stored); err != nil {
+		f.LogfObject("Defaulted", stored)
+		return fmt.Errorf("%s/%s is not defaulted as expected: %v", stored.GetNamespace(), stored.GetName(), err)
+	}
+	return nil
+}
+
+// CreateAndAssertInvariant creates the object, re-fetches the stored version, and verifies the admission pipeline
+// produced a valid, self-consistent object: the stored version passes validation again by a dry-run update, and
+// satisfies the invariant, e.g. that its defaulted fields are consistent with each other.
+func (f *Framework) CreateAndAssertInvariant(obj client.Object, invariant func(server client.Object) error) error {
+	stored, err := f.createAndFetch(obj)
+	if err != nil {
+		return err
+	}
+	dryRun, ok := stored.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("unexpected copy of %T", stored)
+	}
+	if err := f.Client.Update(context.TODO(), dryRun, client.DryRunAll); err != nil {
+		f.LogfObject("Stored", stored)
+		return fmt.Errorf("stored %s/%s does not pass validation again: %v", stored.GetNamespace(), stored.GetName(), err)
+	}
+	if err := invariant(stored); err != nil {
+		f.LogfObject("Stored", stored)
+		return fmt.Errorf("stored %s/%s violates the invariant: %v", stored.GetNamespace(), stored.GetName(), err)
+	}
+	return nil
+}
+
+// getWebhookClientConfigs returns the client configs of the webhooks in the mutating or validating webhook
+// configuration of the name.
+func (f *Framework) getWebhookClientConfigs(name string) ([]admissionregistrationv1.WebhookClientConfig, error) {
+	var configs []admissionregistrationv1.WebhookClientConfig
+	mwhc, err := f.ClientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		for _, wh := range mwhc.Webhooks {
+			configs = append(configs, wh.ClientConfig)
+		}
+		return configs, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	vwhc, err := f.ClientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, wh := range vwhc.Webhooks {
+		configs = append(configs, wh.ClientConfig)
+	}
+	return configs, nil
+}
+
+// checkWebhookActive verifies every webhook of the configuration has a CA bundle, and its service has ready endpoints.
+func (f *Framework) checkWebhookActive(name string) error {
+	configs, err := f.getWebhookClientConfigs(name)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if len(config.CABundle) == 0 {
+			return fmt.Errorf("webhook configuration %s has no CA bundle", name)
+		}
+		if config.Service == nil {
+			continue
+		}
+		ep, err := f.ClientSet.CoreV1().Endpoints(config.Service.Namespace).Get(context.TODO(), config.Service.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("fail to get endpoints of webhook service %s/%s: %v", config.Service.Namespace, config.Service.Name, err)
+		}
+		ready := 0
+		for _, subset := range ep.Subsets {
+			ready += len(subset.Addresses)
+		}
+		if ready == 0 {
+			return fmt.Errorf("webhook service %s/%s has no ready endpoints", config.Service.Namespace, config.Service.Name)
+		}
+	}
+	return nil
+}
+
+// WaitForWebhookActive waits until the mutating or validating webhook configuration of the name exists, has its CA
+// bundle injected, and its service has ready backends, so that tests do not race the webhook registration.
+func (f *Framework) WaitForWebhookActive(webhookConfigName string, timeout time.Duration) error {
+	Logf("Waiting up to %v for webhook configuration %s to be active", timeout, webhookConfigName)
+	var lastErr error
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		lastErr = f.checkWebhookActive(webhookConfigName)
+		return lastErr == nil, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("gave up after waiting %v for webhook configuration %s to be active: %v", timeout, webhookConfigName, lastErr)
+	}
+	return err
+}
+
+// getWebhookMaxTimeout returns the longest timeoutSeconds among the webhooks in the mutating or validating webhook
+// configuration of the name, which defaults to 10s.
+func (f *Framework) getWebhookMaxTimeout(name string) (time.Duration, error) {
+	var timeouts []*int32
+	mwhc, err := f.ClientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		for _, wh := range mwhc.Webhooks {
+			timeouts = append(timeouts, wh.TimeoutSeconds)
+		}
+	} else if apierrors.IsNotFound(err) {
+		vwhc, err := f.ClientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		for _, wh := range vwhc.Webhooks {
+			timeouts = append(timeouts, wh.TimeoutSeconds)
+		}
+	} else {
+		return 0, err
+	}
+
+	var max time.Duration
+	for _, seconds := range timeouts {
+		timeout := 10 * time.Second
+		if seconds != nil {
+			timeout = time.Duration(*seconds) * time.Second
+		}
+		if timeout > max {
+			max = timeout
+		}
+	}
+	return max, nil
+}
+
+// AssertWebhookRespondsWithin runs the probe, e.g. creating an object admitted by the webhooks of the configuration,
+// and fails if the probe fails or its round trip takes longer than maxLatency. The configured timeout of the
+// webhooks is reported together with the latency.
+func (f *Framework) AssertWebhookRespondsWithin(webhookConfigName string, maxLatency time.Duration, probe func() error) error {
+	if err := f.checkWebhookActive(webhookConfigName); err != nil {
+		return err
+	}
+	configuredTimeout, err := f.getWebhookMaxTimeout(webhookConfigName)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = probe()
+	latency := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("probe of webhook configuration %s failed after %v, configured timeout %v: %v", webhookConfigName, latency, configuredTimeout, err)
+	}
+	if latency > maxLatency {
+		return fmt.Errorf("probe of webhook configuration %s took %v, longer than %v, configured timeout %v", webhookConfigName, latency, maxLatency, configuredTimeout)
+	}
+	Logf("Probe of webhook configuration %s took %v, configured timeout %v", webhookConfigName, latency, configuredTimeout)
+	return nil
+}
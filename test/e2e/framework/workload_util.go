@@ -0,0 +1,125 @@
+/*
+Copyright 2023 The KusionStack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// workloadTemplate copies the template and its labels as the selector, where the labels default to app=<name>
+// the same as NewCollaSet, so that native workloads and CollaSets can be built from the same template.
+func workloadTemplate(name string, template corev1.PodTemplateSpec) (corev1.PodTemplateSpec, *metav1.LabelSelector) {
+	template = *template.DeepCopy()
+	if len(template.Labels) == 0 {
+		template.Labels = map[string]string{"app": name}
+	}
+	selector := map[string]string{}
+	for k, v := range template.Labels {
+		selector[k] = v
+	}
+	return template, &metav1.LabelSelector{MatchLabels: selector}
+}
+
+// CreateDeployment creates a Deployment in the framework namespace from the template, for comparing CollaSet
+// with the native controllers.
+func (f *Framework) CreateDeployment(name string, replicas int32, template corev1.PodTemplateSpec) (*appsv1.Deployment, error) {
+	template, selector := workloadTemplate(name, template)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: f.Namespace.Name, Name: name},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: selector,
+			Template: template,
+		},
+	}
+	return f.ClientSet.AppsV1().Deployments(f.Namespace.Name).Create(context.TODO(), deploy, metav1.CreateOptions{})
+}
+
+// CreateStatefulSet creates a StatefulSet in the framework namespace from the template, for comparing CollaSet
+// with the native controllers. Its serviceName is set to its name.
+func (f *Framework) CreateStatefulSet(name string, replicas int32, template corev1.PodTemplateSpec) (*appsv1.StatefulSet, error) {
+	template, selector := workloadTemplate(name, template)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: f.Namespace.Name, Name: name},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			Selector:    selector,
+			Template:    template,
+			ServiceName: name,
+		},
+	}
+	return f.ClientSet.AppsV1().StatefulSets(f.Namespace.Name).Create(context.TODO(), sts, metav1.CreateOptions{})
+}
+
+// WaitForDeploymentReady waits until the Deployment has observed its latest spec, and all its replicas are
+// updated and available.
+func (f *Framework) WaitForDeploymentReady(name string, timeout time.Duration) error {
+	Logf("Waiting up to %v for Deployment %s/%s to be ready", timeout, f.Namespace.Name, name)
+	var deploy *appsv1.Deployment
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if deploy, err = f.ClientSet.AppsV1().Deployments(f.Namespace.Name).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
+		}
+		return deploy.Status.ObservedGeneration >= deploy.Generation &&
+			deploy.Status.Replicas == replicas &&
+			deploy.Status.UpdatedReplicas == replicas &&
+			deploy.Status.AvailableReplicas == replicas, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", deploy)
+		return fmt.Errorf("gave up after waiting %v for Deployment %s/%s to be ready", timeout, f.Namespace.Name, name)
+	}
+	return err
+}
+
+// WaitForStatefulSetReady waits until the StatefulSet has observed its latest spec, and all its replicas are
+// updated and ready.
+func (f *Framework) WaitForStatefulSetReady(name string, timeout time.Duration) error {
+	Logf("Waiting up to %v for StatefulSet %s/%s to be ready", timeout, f.Namespace.Name, name)
+	var sts *appsv1.StatefulSet
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		var err error
+		if sts, err = f.ClientSet.AppsV1().StatefulSets(f.Namespace.Name).Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		return sts.Status.ObservedGeneration >= sts.Generation &&
+			sts.Status.Replicas == replicas &&
+			sts.Status.UpdatedReplicas == replicas &&
+			sts.Status.ReadyReplicas == replicas, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		f.LogfObject("Last observed", sts)
+		return fmt.Errorf("gave up after waiting %v for StatefulSet %s/%s to be ready", timeout, f.Namespace.Name, name)
+	}
+	return err
+}